@@ -1,18 +1,25 @@
 package main
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"strings"
 
 	"megpoid.xyz/go/go-pkgdec/pkg"
-	"net/url"
 )
 
 func checkFatal(err error) {
 	if err != nil {
-		fmt.Fprintf(os.Stderr, err.Error())
+		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
 	}
 }
@@ -27,43 +34,501 @@ func isValidUrl(toTest string) bool {
 	}
 }
 
+// openReaderAt opens the pkg for random access. Returns pkg.ErrRangeNotSupported
+// if the input is an URL whose server cannot serve partial content.
+func openReaderAt(input string, opts *pkg.ReaderOptions) (*pkg.ReaderAt, io.Closer, error) {
+	if isValidUrl(input) {
+		r, err := pkg.OpenURLWithOptions(nil, input, opts)
+		return r, nil, err
+	}
+
+	f, err := os.Open(input)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	r, err := pkg.OpenReaderAtWithOptions(f, fi.Size(), opts)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	return r, f, nil
+}
+
+// openStream opens the pkg for sequential access.
+func openStream(input string, opts *pkg.ReaderOptions) (*pkg.Reader, io.Closer, error) {
+	var rc io.ReadCloser
+
+	if isValidUrl(input) {
+		response, err := http.Get(input)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		rc = response.Body
+	} else {
+		f, err := os.Open(input)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		rc = f
+	}
+
+	r, err := pkg.NewReaderWithOptions(rc, opts)
+	if err != nil {
+		rc.Close()
+		return nil, nil, err
+	}
+
+	return r, rc, nil
+}
+
+// openReader opens the pkg using random access when possible.
+func openReader(input string, opts *pkg.ReaderOptions) (*pkg.Reader, *pkg.ReaderAt, io.Closer) {
+	ra, closer, err := openReaderAt(input, opts)
+	if err == nil {
+		return &ra.Reader, ra, closer
+	}
+
+	if err != pkg.ErrRangeNotSupported {
+		checkFatal(err)
+	}
+
+	r, closer, err := openStream(input, opts)
+	checkFatal(err)
+
+	return r, nil, closer
+}
+
+// readerOptions returns the options with the zRIF license and the keys of
+// the key file, if any.
+func readerOptions(license, keyFile string) *pkg.ReaderOptions {
+	opts := &pkg.ReaderOptions{ZRIF: license}
+
+	if keyFile != "" {
+		keys, err := pkg.LoadKeyFile(keyFile)
+		checkFatal(err)
+
+		opts.Keys = keys
+	}
+
+	return opts
+}
+
+// parseLanguages decodes a comma separated list of title languages.
+func parseLanguages(list string) []pkg.Language {
+	if list == "" {
+		return nil
+	}
+
+	var langs []pkg.Language
+	for _, code := range strings.Split(list, ",") {
+		lang, err := pkg.ParseLanguage(strings.TrimSpace(code))
+		checkFatal(err)
+
+		langs = append(langs, lang)
+	}
+
+	return langs
+}
+
+func closeInput(c io.Closer) {
+	if c != nil {
+		c.Close()
+	}
+}
+
+func infoCommand(args []string) {
+	flags := flag.NewFlagSet("info", flag.ExitOnError)
+	license := flags.String("l", "", "License in zRIF format")
+	keys := flags.String("keys", "", "Key file with extra or replaced keys")
+	list := flags.Bool("files", false, "List the files inside the package")
+	lang := flags.String("lang", "", "Preferred languages of the title, like ja or fr,en")
+
+	flags.Parse(args)
+	opts := readerOptions(*license, *keys)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage of %s info: [options] <file.pkg or URL>\n", os.Args[0])
+		flags.PrintDefaults()
+		os.Exit(1)
+	}
+
+	langs := parseLanguages(*lang)
+
+	if !*list {
+		probeInfo(flags.Arg(0), langs)
+		return
+	}
+
+	r, ra, closer := openReader(flags.Arg(0), opts)
+	defer closeInput(closer)
+
+	r.TitleLanguages = langs
+
+	fmt.Printf("Title:      %s\n", r.GetTitle())
+	fmt.Printf("Title ID:   %s\n", r.GetTitleID())
+	fmt.Printf("Content ID: %s\n", r.FileHeader.GetContentID())
+	fmt.Printf("Region:     %s\n", r.GetRegion())
+	fmt.Printf("Type:       %s\n", r.PackageType())
+	fmt.Printf("Size:       %d\n", r.FileHeader.TotalSize)
+	printParams(r.AppParams())
+
+	if ra != nil && ra.PackageType() == pkg.PackageTypePSOne {
+		ids, err := ra.DiscIDs()
+		if err != nil {
+			fmt.Printf("Discs:      unknown (%v)\n", err)
+		} else {
+			fmt.Printf("Discs:      %d %v\n", len(ids), ids)
+		}
+	}
+
+	entries := r.Entries()
+	for idx := range entries {
+		entry := &entries[idx]
+		fmt.Printf("%10d  %-12s  %s\n", entry.Size(), entry.FileType(), entry.Name())
+	}
+}
+
+// printParams prints the SFO parameters known by the package.
+func printParams(params *pkg.AppParams) {
+	if params.CategoryCode != "" {
+		fmt.Printf("Category:   %s (%s)\n", params.Category, params.CategoryCode)
+	}
+
+	if !params.AppVersion.IsZero() {
+		fmt.Printf("Version:    %s\n", params.AppVersion)
+	}
+
+	if !params.SystemVersion.IsZero() {
+		fmt.Printf("Firmware:   %s\n", params.SystemVersion)
+	}
+}
+
+// probeInfo prints the package metadata reading only the start of the file.
+func probeInfo(input string, langs []pkg.Language) {
+	var rc io.ReadCloser
+
+	if isValidUrl(input) {
+		response, err := http.Get(input)
+		checkFatal(err)
+		rc = response.Body
+	} else {
+		f, err := os.Open(input)
+		checkFatal(err)
+		rc = f
+	}
+
+	defer rc.Close()
+
+	info, err := pkg.Probe(rc)
+	checkFatal(err)
+
+	title := ""
+	if info.SFO != nil {
+		title = info.SFO.TitleFor(langs...)
+	}
+
+	fmt.Printf("Title:      %s\n", title)
+	fmt.Printf("Title ID:   %s\n", info.TitleID)
+	fmt.Printf("Content ID: %s\n", info.ContentID)
+	fmt.Printf("Region:     %s\n", info.Region)
+	fmt.Printf("Type:       %s\n", info.PackageType)
+	fmt.Printf("Size:       %d\n", info.TotalSize)
+	printParams(pkg.NewAppParams(info.SFO))
+
+	if discID, ok := info.SfoEntries["DISC_ID"]; ok {
+		fmt.Printf("Disc ID:    %s\n", discID)
+	}
+}
+
+// extractFile writes a single entry of the pkg to the output directory.
+func extractFile(r *pkg.Reader, ra *pkg.ReaderAt, name, outDir string) error {
+	var src io.Reader
+
+	if ra != nil {
+		entry, ok := ra.Lookup(name)
+		if !ok {
+			return fmt.Errorf("file not found in package: %s", name)
+		}
+
+		sr, err := ra.OpenEntry(entry)
+		if err != nil {
+			return err
+		}
+
+		src = sr
+	} else {
+		for {
+			entry, err := r.Next()
+			if err == io.EOF {
+				return fmt.Errorf("file not found in package: %s", name)
+			}
+
+			if err != nil {
+				return err
+			}
+
+			if entry.Name() == name {
+				if entry.IsDirectory() {
+					return errors.New("cannot extract a directory")
+				}
+
+				src = r
+				break
+			}
+		}
+	}
+
+	f, err := os.Create(path.Join(outDir, path.Base(name)))
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	_, err = io.Copy(f, src)
+	return err
+}
+
+func verifyCommand(args []string) {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	license := flags.String("l", "", "License in zRIF format")
+	keys := flags.String("keys", "", "Key file with extra or replaced keys")
+
+	flags.Parse(args)
+	opts := readerOptions(*license, *keys)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage of %s verify: [options] <file.pkg or URL>\n", os.Args[0])
+		flags.PrintDefaults()
+		os.Exit(1)
+	}
+
+	r, closer, err := openStream(flags.Arg(0), opts)
+	checkFatal(err)
+	defer closer.Close()
+
+	header, err := r.VerifyHeader()
+	checkFatal(err)
+
+	fmt.Printf("Header SHA1:      %s\n", header.SHA1)
+	fmt.Printf("Header CMAC:      %s\n", header.CMAC)
+	fmt.Printf("Header signature: %s\n", header.Signature)
+
+	result, err := r.Verify(context.Background())
+	checkFatal(err)
+
+	fmt.Printf("Tail signature:   %s\n", result.Tail.Signature)
+
+	if result.HashOK() {
+		fmt.Printf("PKG hash check OK\n")
+	} else {
+		fmt.Printf("PKG SHA1 check failed\n")
+		fmt.Printf("Actual:   %x\n", result.CalculatedHash)
+		fmt.Printf("Expected: %x\n", result.FileHash)
+	}
+
+	if result.SizeOK() {
+		fmt.Printf("PKG size check OK\n")
+	} else {
+		fmt.Printf("PKG size check failed\n")
+		fmt.Printf("Actual:   %d\n", result.Size)
+		fmt.Printf("Expected: %d\n", result.ExpectedSize)
+	}
+
+	if !result.Valid() || !header.Valid() {
+		os.Exit(1)
+	}
+}
+
+func decryptCommand(args []string) {
+	flags := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	license := flags.String("l", "", "License in zRIF format")
+	keys := flags.String("keys", "", "Key file with extra or replaced keys")
+
+	flags.Parse(args)
+	opts := readerOptions(*license, *keys)
+
+	if flags.NArg() != 2 {
+		fmt.Fprintf(os.Stderr, "Usage of %s decrypt: [options] <file.pkg or URL> <output.pkg>\n", os.Args[0])
+		flags.PrintDefaults()
+		os.Exit(1)
+	}
+
+	r, closer, err := openStream(flags.Arg(0), opts)
+	checkFatal(err)
+	defer closer.Close()
+
+	out, err := os.Create(flags.Arg(1))
+	checkFatal(err)
+
+	checkFatal(r.WriteDecrypted(out))
+	checkFatal(out.Close())
+
+	if !r.Valid() {
+		fmt.Printf("PKG SHA1 check failed\n")
+		fmt.Printf("Actual:   %x\n", r.CalculatedHash)
+		fmt.Printf("Expected: %x\n", r.FileHash)
+		os.Exit(1)
+	}
+}
+
+// loadEDATKey reads the klicensee from a .rap or a .rif license file.
+func loadEDATKey(licenseFile, actDatFile, idps string) ([]byte, error) {
+	if licenseFile == "" {
+		return nil, nil
+	}
+
+	license, err := ioutil.ReadFile(licenseFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var actDat, idpsKey []byte
+
+	if actDatFile != "" {
+		actDat, err = ioutil.ReadFile(actDatFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if idps != "" {
+		idpsKey, err = hex.DecodeString(idps)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return pkg.EDATKlicensee(license, actDat, idpsKey)
+}
+
+func edatCommand(args []string) {
+	flags := flag.NewFlagSet("edat", flag.ExitOnError)
+	license := flags.String("rap", "", "License of the EDAT in RAP or RIF format")
+	actDat := flags.String("act", "", "act.dat of the account, needed by RIF licenses")
+	idps := flags.String("idps", "", "IDPS of the console in hex, needed by RIF licenses")
+
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		fmt.Fprintf(os.Stderr, "Usage of %s edat: [options] <file.edat> <output>\n", os.Args[0])
+		flags.PrintDefaults()
+		os.Exit(1)
+	}
+
+	key, err := loadEDATKey(*license, *actDat, *idps)
+	checkFatal(err)
+
+	in, err := os.Open(flags.Arg(0))
+	checkFatal(err)
+	defer in.Close()
+
+	out, err := os.Create(flags.Arg(1))
+	checkFatal(err)
+	defer out.Close()
+
+	checkFatal(pkg.DecryptEDAT(in, out, key))
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "info":
+			infoCommand(os.Args[2:])
+			return
+		case "verify":
+			verifyCommand(os.Args[2:])
+			return
+		case "edat":
+			edatCommand(os.Args[2:])
+			return
+		case "decrypt":
+			decryptCommand(os.Args[2:])
+			return
+		}
+	}
+
 	input := flag.String("i", "", "Package file or URL (required)")
 	license := flag.String("l", "", "License in zRIF format")
 	output := flag.String("o", "", "Directory to extract the files")
 	zipped := flag.Bool("z", false, "Create a zipfile from the pkg file")
+	single := flag.String("f", "", "Extract only the given file from the package")
+	format := flag.String("format", "pbp", "Output format of PSP games: pbp, iso, cso or zso (bincue for PSOne games)")
+	blockSize := flag.Uint("block-size", 2048, "Block size of CSO/ZSO images")
+	level := flag.Int("level", 9, "Deflate compression level of CSO images")
+	keys := flag.String("keys", "", "Key file with extra or replaced keys")
+	edat := flag.Bool("edat", false, "Decrypt the EDAT and SDAT files of PS3 packages")
+	rap := flag.String("rap", "", "License of the EDAT files in RAP or RIF format")
+	actDat := flag.String("act", "", "act.dat of the account, needed by RIF licenses")
+	idps := flag.String("idps", "", "IDPS of the console in hex, needed by RIF licenses")
+	lang := flag.String("lang", "", "Preferred languages of the title used in the output names, like ja or fr,en")
 
 	flag.Parse()
 
 	if *input == "" && *output == "" {
 		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s info [options] <file.pkg or URL>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s verify [options] <file.pkg or URL>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s edat [options] <file.edat> <output>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s decrypt [options] <file.pkg or URL> <output.pkg>\n", os.Args[0])
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
-	var r *pkg.Reader
-	var err error
+	opts := readerOptions(*license, *keys)
 
-	if isValidUrl(*input) {
-		response, err := http.Get(*input)
-		checkFatal(err)
-		defer response.Body.Close()
+	if *single != "" {
+		r, ra, closer := openReader(*input, opts)
+		defer closeInput(closer)
 
-		r, err = pkg.NewReader(response.Body, *license)
-		checkFatal(err)
-	} else {
-		f, err := os.Open(*input)
-		checkFatal(err)
-		defer f.Close()
+		checkFatal(extractFile(r, ra, *single, *output))
+		return
+	}
 
-		r, err = pkg.NewReader(f, *license)
-		checkFatal(err)
+	r, closer, err := openStream(*input, opts)
+	checkFatal(err)
+	defer closer.Close()
+
+	switch *format {
+	case "pbp":
+		r.PSPOutput = pkg.PSPOutputPBP
+	case "iso":
+		r.PSPOutput = pkg.PSPOutputISO
+	case "cso":
+		r.PSPOutput = pkg.PSPOutputCSO
+	case "zso":
+		r.PSPOutput = pkg.PSPOutputZSO
+	case "bincue":
+		// the discs are converted by UnpackBinCue or CreateBinCueZip below
+	default:
+		checkFatal(fmt.Errorf("unknown output format: %s", *format))
 	}
 
+	r.CSOOptions = pkg.CSOOptions{BlockSize: uint32(*blockSize), Level: *level}
+	r.DecryptEDAT = *edat
+	r.TitleLanguages = parseLanguages(*lang)
+
+	r.EDATKey, err = loadEDATKey(*rap, *actDat, *idps)
+	checkFatal(err)
+
 	title := r.GetTitle()
 	fmt.Printf("Unpacking %s\n", title)
 
-	if !*zipped {
+	if *format == "bincue" && *zipped {
+		err = r.CreateBinCueZip(*output)
+	} else if *format == "bincue" {
+		err = r.UnpackBinCue(*output)
+	} else if !*zipped {
 		err = r.Unpack(*output)
 	} else {
 		err = r.CreateZip(*output)
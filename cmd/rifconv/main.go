@@ -6,21 +6,50 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"time"
 
 	"megpoid.xyz/go/go-pkgdec/pkg"
 )
 
 func checkFatal(err error) {
 	if err != nil {
-		fmt.Fprintf(os.Stderr, err.Error())
+		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
 	}
 }
 
+// printLicense shows the fields of a Vita or PSM license.
+func printLicense(data []byte) {
+	lic, err := pkg.ParseLicense(data)
+	checkFatal(err)
+
+	fmt.Printf("Format:     %s\n", lic.Format)
+	fmt.Printf("Content ID: %s\n", lic.ContentID)
+	fmt.Printf("Account ID: 0x%016x\n", lic.AccountID)
+	fmt.Printf("Bound:      %v\n", lic.IsAccountBound())
+
+	if lic.Format == pkg.LicenseVita {
+		fmt.Printf("Version:    %d (flags 0x%04x)\n", lic.Version, lic.VersionFlags)
+		fmt.Printf("Type:       %d\n", lic.Type)
+		fmt.Printf("Flags:      0x%04x\n", lic.Flags)
+	}
+
+	fmt.Printf("Key:        %x\n", lic.Key)
+
+	if !lic.StartTime.IsZero() {
+		fmt.Printf("Start:      %s\n", lic.StartTime)
+	}
+
+	if !lic.ExpirationTime.IsZero() {
+		fmt.Printf("Expiration: %s (expired: %v)\n", lic.ExpirationTime, lic.IsExpired(time.Now()))
+	}
+}
+
 func main() {
 	license := flag.String("l", "", "License in zRIF format")
 	input := flag.String("i", "", "Package file")
 	output := flag.String("o", "", "Directory created to extract the files")
+	info := flag.Bool("info", false, "Print the contents of the license instead of converting it")
 
 	flag.Parse()
 
@@ -38,7 +67,9 @@ func main() {
 		lic, err := pkg.DecodeLicense(*license, 0)
 		checkFatal(err)
 
-		if *output != "" {
+		if *info {
+			printLicense(lic)
+		} else if *output != "" {
 			err = ioutil.WriteFile(*output, lic, 0644)
 			checkFatal(err)
 		} else {
@@ -48,6 +79,11 @@ func main() {
 		lic, err := ioutil.ReadFile(*input)
 		checkFatal(err)
 
+		if *info {
+			printLicense(lic)
+			return
+		}
+
 		rif, err := pkg.EncodeLicense(lic)
 		checkFatal(err)
 
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"megpoid.xyz/go/go-pkgdec/pkg"
+)
+
+var pkgMagic = []byte{0x7f, 'P', 'K', 'G'}
+
+func checkFatal(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+// readPackageSFO returns the SFO of a package, from the metadata if present
+// or from the PARAM.SFO file otherwise.
+func readPackageSFO(f *os.File) (*pkg.SFO, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := pkg.OpenReaderAt(f, fi.Size(), "")
+	if err != nil {
+		return nil, err
+	}
+
+	if r.SFO != nil {
+		return r.SFO, nil
+	}
+
+	entries := r.Entries()
+	for idx := range entries {
+		entry := &entries[idx]
+		if !strings.HasSuffix(strings.ToUpper(entry.Name()), "PARAM.SFO") {
+			continue
+		}
+
+		data, err := r.OpenEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		sfo := pkg.NewSFO()
+		if _, err := sfo.Parse(data); err != nil {
+			return nil, err
+		}
+
+		return sfo, nil
+	}
+
+	return nil, errors.New("the package has no PARAM.SFO")
+}
+
+// readSFO reads a PARAM.SFO file, or the SFO embedded in a package. The
+// second value reports whether the file is a package.
+func readSFO(name string) (*pkg.SFO, bool, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, false, err
+	}
+
+	defer f.Close()
+
+	magic := make([]byte, len(pkgMagic))
+	if _, err := f.ReadAt(magic, 0); err == nil && bytes.Equal(magic, pkgMagic) {
+		sfo, err := readPackageSFO(f)
+		return sfo, true, err
+	}
+
+	sfo := pkg.NewSFO()
+	if _, err := sfo.Parse(bufio.NewReader(f)); err != nil {
+		return nil, false, fmt.Errorf("%s: %v", name, err)
+	}
+
+	return sfo, false, nil
+}
+
+// openEditable reads a loose PARAM.SFO, the packages can't be modified.
+func openEditable(name string) *pkg.SFO {
+	sfo, isPkg, err := readSFO(name)
+	checkFatal(err)
+
+	if isPkg {
+		checkFatal(errors.New("the SFO of a package is read-only"))
+	}
+
+	return sfo
+}
+
+func writeSFO(sfo *pkg.SFO, name string) {
+	buf := bytes.Buffer{}
+	_, err := sfo.WriteTo(&buf)
+	checkFatal(err)
+
+	checkFatal(ioutil.WriteFile(name, buf.Bytes(), 0644))
+}
+
+func parseFormat(format string) (pkg.SFOFormat, error) {
+	switch format {
+	case "utf8", "string", "str":
+		return pkg.SFOFormatUTF8, nil
+	case "utf8-special":
+		return pkg.SFOFormatUTF8Special, nil
+	case "integer", "int":
+		return pkg.SFOFormatInteger, nil
+	default:
+		return 0, fmt.Errorf("unknown SFO format: %s", format)
+	}
+}
+
+// setValue stores the value in the parameter following its type.
+func setValue(param *pkg.SFOParam, value string) error {
+	if param.Format != pkg.SFOFormatInteger {
+		return param.SetString(value)
+	}
+
+	n, err := strconv.ParseUint(value, 0, 32)
+	if err != nil {
+		return fmt.Errorf("invalid integer value for %s: %s", param.Key, value)
+	}
+
+	return param.SetInt(uint32(n))
+}
+
+type jsonParam struct {
+	Key       string      `json:"key"`
+	Format    string      `json:"format"`
+	MaxLength uint32      `json:"max_length"`
+	Value     interface{} `json:"value"`
+}
+
+func dumpJSON(sfo *pkg.SFO) {
+	params := make([]jsonParam, len(sfo.Params))
+	for i, p := range sfo.Params {
+		params[i] = jsonParam{Key: p.Key, Format: p.Format.String(), MaxLength: p.MaxLength}
+		if p.Format == pkg.SFOFormatInteger {
+			params[i].Value = p.Int()
+		} else {
+			params[i].Value = p.String()
+		}
+	}
+
+	data, err := json.MarshalIndent(params, "", "  ")
+	checkFatal(err)
+
+	fmt.Println(string(data))
+}
+
+func dumpCommand(args []string) {
+	flags := flag.NewFlagSet("dump", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "Print the parameters as JSON")
+
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage of %s dump: [options] <PARAM.SFO or file.pkg>\n", os.Args[0])
+		flags.PrintDefaults()
+		os.Exit(1)
+	}
+
+	sfo, _, err := readSFO(flags.Arg(0))
+	checkFatal(err)
+
+	if *asJSON {
+		dumpJSON(sfo)
+		return
+	}
+
+	for _, p := range sfo.Params {
+		value := p.String()
+		if p.Format == pkg.SFOFormatInteger {
+			value = fmt.Sprintf("0x%08x (%d)", p.Int(), p.Int())
+		}
+
+		fmt.Printf("%-22s %-12s %5d  %s\n", p.Key, p.Format, p.MaxLength, value)
+	}
+}
+
+func getCommand(args []string) {
+	flags := flag.NewFlagSet("get", flag.ExitOnError)
+
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		fmt.Fprintf(os.Stderr, "Usage of %s get: <PARAM.SFO or file.pkg> <key>\n", os.Args[0])
+		os.Exit(1)
+	}
+
+	sfo, _, err := readSFO(flags.Arg(0))
+	checkFatal(err)
+
+	param := sfo.Get(flags.Arg(1))
+	if param == nil {
+		checkFatal(fmt.Errorf("key not found: %s", flags.Arg(1)))
+	}
+
+	fmt.Println(param.String())
+}
+
+func setCommand(args []string) {
+	flags := flag.NewFlagSet("set", flag.ExitOnError)
+	output := flags.String("o", "", "Write the result to this file instead of replacing the input")
+
+	flags.Parse(args)
+
+	if flags.NArg() != 3 {
+		fmt.Fprintf(os.Stderr, "Usage of %s set: [options] <PARAM.SFO> <key> <value>\n", os.Args[0])
+		flags.PrintDefaults()
+		os.Exit(1)
+	}
+
+	sfo := openEditable(flags.Arg(0))
+
+	param := sfo.Get(flags.Arg(1))
+	if param == nil {
+		checkFatal(fmt.Errorf("key not found: %s", flags.Arg(1)))
+	}
+
+	checkFatal(setValue(param, flags.Arg(2)))
+
+	writeSFO(sfo, outputName(*output, flags.Arg(0)))
+}
+
+func addCommand(args []string) {
+	flags := flag.NewFlagSet("add", flag.ExitOnError)
+	output := flags.String("o", "", "Write the result to this file instead of replacing the input")
+	format := flags.String("format", "utf8", "Type of the value: utf8, utf8-special or integer")
+	maxLength := flags.Uint("max", 0, "Space reserved for the string value, the length of the value by default")
+
+	flags.Parse(args)
+
+	if flags.NArg() != 3 {
+		fmt.Fprintf(os.Stderr, "Usage of %s add: [options] <PARAM.SFO> <key> <value>\n", os.Args[0])
+		flags.PrintDefaults()
+		os.Exit(1)
+	}
+
+	sfoFormat, err := parseFormat(*format)
+	checkFatal(err)
+
+	size := uint32(*maxLength)
+	if size == 0 {
+		size = uint32(len(flags.Arg(2)) + 1)
+	}
+
+	sfo := openEditable(flags.Arg(0))
+
+	param, err := sfo.Add(flags.Arg(1), sfoFormat, size)
+	checkFatal(err)
+
+	checkFatal(setValue(param, flags.Arg(2)))
+
+	writeSFO(sfo, outputName(*output, flags.Arg(0)))
+}
+
+func removeCommand(args []string) {
+	flags := flag.NewFlagSet("remove", flag.ExitOnError)
+	output := flags.String("o", "", "Write the result to this file instead of replacing the input")
+
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		fmt.Fprintf(os.Stderr, "Usage of %s remove: [options] <PARAM.SFO> <key>\n", os.Args[0])
+		flags.PrintDefaults()
+		os.Exit(1)
+	}
+
+	sfo := openEditable(flags.Arg(0))
+
+	if !sfo.Remove(flags.Arg(1)) {
+		checkFatal(fmt.Errorf("key not found: %s", flags.Arg(1)))
+	}
+
+	writeSFO(sfo, outputName(*output, flags.Arg(0)))
+}
+
+func outputName(output, input string) string {
+	if output == "" {
+		return input
+	}
+
+	return output
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "dump":
+			dumpCommand(os.Args[2:])
+			return
+		case "get":
+			getCommand(os.Args[2:])
+			return
+		case "set":
+			setCommand(os.Args[2:])
+			return
+		case "add":
+			addCommand(os.Args[2:])
+			return
+		case "remove":
+			removeCommand(os.Args[2:])
+			return
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s dump [-json] <PARAM.SFO or file.pkg>\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s get <PARAM.SFO or file.pkg> <key>\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s set [-o output] <PARAM.SFO> <key> <value>\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s add [-o output] [-format utf8] [-max length] <PARAM.SFO> <key> <value>\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s remove [-o output] <PARAM.SFO> <key>\n", os.Args[0])
+	os.Exit(1)
+}
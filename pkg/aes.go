@@ -41,6 +41,10 @@ func NewCTRReader(r io.Reader, key, iv []byte, counter int64) (*ctrStream, error
 		return nil, err
 	}
 
+	return newCTRStream(r, block, iv, counter), nil
+}
+
+func newCTRStream(r io.Reader, block cipher.Block, iv []byte, counter int64) *ctrStream {
 	stream := NewCTR(block, iv, counter)
 
 	return &ctrStream{
@@ -48,7 +52,7 @@ func NewCTRReader(r io.Reader, key, iv []byte, counter int64) (*ctrStream, error
 		stream: stream,
 		reader: cipher.StreamReader{R: r, S: stream},
 		iv:     dup(iv),
-	}, nil
+	}
 }
 
 func NewCTR(b cipher.Block, iv []byte, counter int64) cipher.Stream {
@@ -100,3 +104,64 @@ func dup(p []byte) []byte {
 	copy(q, p)
 	return q
 }
+
+// AESCMAC calculates the AES-CMAC (RFC 4493) of the data.
+func AESCMAC(key, data []byte) ([]byte, error) {
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	k1 := make([]byte, aes.BlockSize)
+	c.Encrypt(k1, k1)
+	k1 = cmacSubkey(k1)
+	k2 := cmacSubkey(k1)
+
+	n := (len(data) + aes.BlockSize - 1) / aes.BlockSize
+	if n == 0 {
+		n = 1
+	}
+
+	last := make([]byte, aes.BlockSize)
+	rest := data[(n-1)*aes.BlockSize:]
+
+	if len(rest) == aes.BlockSize {
+		xorBytes(last, rest, k1)
+	} else {
+		copy(last, rest)
+		last[len(rest)] = 0x80
+		xorBytes(last, last, k2)
+	}
+
+	mac := make([]byte, aes.BlockSize)
+	for i := 0; i < n-1; i++ {
+		xorBytes(mac, mac, data[i*aes.BlockSize:(i+1)*aes.BlockSize])
+		c.Encrypt(mac, mac)
+	}
+
+	xorBytes(mac, mac, last)
+	c.Encrypt(mac, mac)
+
+	return mac, nil
+}
+
+func cmacSubkey(k []byte) []byte {
+	sub := make([]byte, len(k))
+	var carry byte
+	for i := len(k) - 1; i >= 0; i-- {
+		sub[i] = k[i]<<1 | carry
+		carry = k[i] >> 7
+	}
+
+	if carry != 0 {
+		sub[len(sub)-1] ^= 0x87
+	}
+
+	return sub
+}
+
+func xorBytes(dst, a, b []byte) {
+	for i := range dst {
+		dst[i] = a[i] ^ b[i]
+	}
+}
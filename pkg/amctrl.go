@@ -0,0 +1,186 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+)
+
+// The AMCTRL functions of the PSP protect the NPDRM images and the PGD files.
+// They are built on the KIRK engine commands 4 and 7, AES-128-CBC with a key
+// selected by a key seed, so they can be done in software for the seeds used
+// by the retail images. The type 2 MAC and cipher use the console keys and
+// aren't supported.
+
+const (
+	bbMacType1 = 1
+	bbMacType3 = 3
+)
+
+var pgdMagic = []byte("\x00PGD")
+
+const (
+	// size of the header with its MACs, the offset of the data is stored
+	// at 0x4c
+	pgdHeaderSize = 0x90
+	// area of the header covered by the version key MAC, the MAC follows it
+	pgdMacSize = 0x70
+	// DRM type of the PGD files decrypted with the fixed keys
+	pgdDRMTypeFixed = 1
+)
+
+// ErrBBMacType is returned for the AMCTRL types bound to the console.
+var ErrBBMacType = errors.New("pkg: unsupported AMCTRL type")
+
+// bbVersionKey recovers the version key protected by the MAC of the data,
+// stored in bbmac. The MAC is the AES-CMAC of the data xored with the mask and
+// the version key, then encrypted with the key 0x38. The type 3 MACs are
+// encrypted again with the key 0x63.
+func bbVersionKey(macType int, data, bbmac []byte) ([]byte, error) {
+	if macType != bbMacType1 && macType != bbMacType3 {
+		return nil, ErrBBMacType
+	}
+
+	mac, err := AESCMAC(kirkKey38, data)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := dup(bbmac[:aes.BlockSize])
+	if macType == bbMacType3 {
+		c, err := aes.NewCipher(kirkKey63)
+		if err != nil {
+			return nil, err
+		}
+
+		c.Decrypt(stored, stored)
+	}
+
+	c, err := aes.NewCipher(kirkKey38)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Decrypt(stored, stored)
+
+	key := make([]byte, aes.BlockSize)
+	for i := range key {
+		key[i] = mac[i] ^ amctrlMacMask[i] ^ stored[i]
+	}
+
+	return key, nil
+}
+
+// A bbCipher is the type 1 AMCTRL cipher, a keystream made by decrypting
+// counter blocks in CBC mode. Decryption and encryption are the same.
+type bbCipher struct {
+	block cipher.Block
+	// first 12 bytes of the counter blocks, the counter is the last word
+	prefix [aes.BlockSize]byte
+	seed   uint32
+}
+
+// newBBCipher creates the cipher of the data protected by the header key and
+// the version key. The seed is the position of the data in 16 byte blocks.
+func newBBCipher(headerKey, versionKey []byte, seed uint32) (*bbCipher, error) {
+	key := make([]byte, aes.BlockSize)
+	for i := range key {
+		key[i] = headerKey[i] ^ versionKey[i] ^ amctrlKeyMask[i]
+	}
+
+	c, err := aes.NewCipher(kirkKey39)
+	if err != nil {
+		return nil, err
+	}
+
+	bc := &bbCipher{seed: seed + 1}
+	c.Decrypt(bc.prefix[:], key)
+	xorBytes(bc.prefix[:], bc.prefix[:], amctrlSeedMask)
+
+	bc.block, err = aes.NewCipher(kirkKey63)
+	if err != nil {
+		return nil, err
+	}
+
+	return bc, nil
+}
+
+func (bc *bbCipher) counter(seed uint32) []byte {
+	block := dup(bc.prefix[:])
+	binary.LittleEndian.PutUint32(block[12:], seed)
+	return block
+}
+
+// XORKeyStream decrypts src into dst, advancing the counter.
+func (bc *bbCipher) XORKeyStream(dst, src []byte) {
+	stream := make([]byte, aes.BlockSize)
+
+	for len(src) > 0 {
+		// the CBC chaining value is the previous counter block, except for
+		// the first one
+		bc.block.Decrypt(stream, bc.counter(bc.seed))
+		if bc.seed != 1 {
+			xorBytes(stream, stream, bc.counter(bc.seed-1))
+		}
+
+		n := len(src)
+		if n > aes.BlockSize {
+			n = aes.BlockSize
+		}
+
+		xorBytes(dst[:n], src[:n], stream[:n])
+		dst, src = dst[n:], src[n:]
+		bc.seed++
+	}
+}
+
+// decryptPGD decrypts a PGD file in place and returns its data. The MACs of
+// the header and of the data blocks aren't checked.
+func decryptPGD(pgd []byte) ([]byte, error) {
+	if len(pgd) < pgdHeaderSize || !bytes.HasPrefix(pgd, pgdMagic) {
+		return nil, errors.New("pkg: invalid PGD header")
+	}
+
+	keyIndex := binary.LittleEndian.Uint32(pgd[4:])
+	drmType := binary.LittleEndian.Uint32(pgd[8:])
+	if drmType != pgdDRMTypeFixed {
+		return nil, ErrBBMacType
+	}
+
+	macType := bbMacType1
+	if keyIndex > 1 {
+		macType = bbMacType3
+	}
+
+	versionKey, err := bbVersionKey(macType, pgd[:pgdMacSize], pgd[pgdMacSize:])
+	if err != nil {
+		return nil, err
+	}
+
+	// the second part of the header has the data key and the data size
+	c, err := newBBCipher(pgd[0x10:0x20], versionKey, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	c.XORKeyStream(pgd[0x30:0x60], pgd[0x30:0x60])
+
+	size := int64(binary.LittleEndian.Uint32(pgd[0x44:]))
+	offset := int64(binary.LittleEndian.Uint32(pgd[0x4c:]))
+	alignedSize := (size + 15) &^ 15
+	if offset < pgdHeaderSize || offset+alignedSize > int64(len(pgd)) {
+		return nil, errors.New("pkg: invalid PGD data size")
+	}
+
+	c, err = newBBCipher(pgd[0x30:0x40], versionKey, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	data := pgd[offset : offset+alignedSize]
+	c.XORKeyStream(data, data)
+
+	return data[:size], nil
+}
@@ -0,0 +1,399 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	// pkg type of PSP, PS Vita and PSM packages
+	typePSP = 2
+	// offset of the metadata records, right after both headers
+	builderInfoOffset = 0xC0 + 0x40
+)
+
+// A Writer creates encrypted pkg archives from a file tree.
+type Writer struct {
+	ContentID   string
+	ContentType ContentTypeEnum
+	KeyType     int
+	DrmType     uint32
+	// DataIV is generated randomly when left empty
+	DataIV [16]byte
+	// Debug creates a debug package, encrypted with the keystream derived
+	// from Digest instead of the retail keys
+	Debug  bool
+	Digest [16]byte
+	// Keys used to encrypt the package, DefaultKeys if nil
+	Keys KeyProvider
+}
+
+// builderEntry is a file or directory to be stored in the pkg.
+type builderEntry struct {
+	name   string
+	dir    bool
+	size   int64
+	offset int64
+}
+
+func NewWriter(contentID string, contentType ContentTypeEnum, keyType int) *Writer {
+	return &Writer{
+		ContentID:   contentID,
+		ContentType: contentType,
+		KeyType:     keyType,
+	}
+}
+
+// WriteDir creates a pkg with the contents of the directory.
+func (pw *Writer) WriteDir(w io.Writer, dir string) error {
+	return pw.WriteFS(w, os.DirFS(dir))
+}
+
+// WriteFS creates a pkg with the contents of the filesystem. A PARAM.SFO at the
+// root of the tree or in sce_sys is also embedded in the pkg metadata.
+func (pw *Writer) WriteFS(w io.Writer, fsys fs.FS) error {
+	if len(pw.ContentID) != len(FileHeader{}.ContentID) {
+		return errors.New("pkg: invalid content ID length")
+	}
+
+	entries, sfo, err := collectEntries(fsys)
+	if err != nil {
+		return err
+	}
+
+	if len(entries) == 0 {
+		return errors.New("pkg: no files to add")
+	}
+
+	// a new IV for every package, the keystream can't be reused
+	dataIV := pw.DataIV
+	if dataIV == [16]byte{} {
+		if _, err := rand.Read(dataIV[:]); err != nil {
+			return err
+		}
+	}
+
+	pkgType := uint16(typePSP)
+	keyType := pw.KeyType
+	// key type stored in the extended header, unused by PS3 packages
+	dataType := uint32(pw.KeyType)
+
+	if isPS3Content(pw.ContentType) {
+		pkgType = fileTypePS3
+		keyType = KeyTypePS3
+		dataType = 0
+	}
+
+	keys := pw.Keys
+	if keys == nil {
+		keys = DefaultKeys
+	}
+
+	key, err := keys.PackageKey(keyType, dataIV[:])
+	if err != nil {
+		return err
+	}
+
+	var block cipher.Block
+	revision := uint16(revisionRetail)
+
+	if pw.Debug {
+		block = newDebugCipher(pw.Digest[:], dataIV[:])
+		revision = 0
+	} else {
+		block, err = aes.NewCipher(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	// data section layout: item records, names table and file data
+	recordListSize := int64(len(entries) * binary.Size(ItemRecord{}))
+	records := make([]ItemRecord, len(entries))
+	names := bytes.Buffer{}
+
+	for idx, entry := range entries {
+		records[idx].FilenameOffset = uint32(recordListSize + int64(names.Len()))
+		records[idx].FilenameSize = int32(len(entry.name))
+		names.WriteString(entry.name)
+		names.Write(make([]byte, align16(int64(len(entry.name)))-int64(len(entry.name))))
+	}
+
+	cursor := recordListSize + int64(names.Len())
+
+	for idx := range entries {
+		entry := &entries[idx]
+		entry.offset = cursor
+		records[idx].DataOffset = cursor
+		records[idx].Flags = pw.entryFlags(entry)
+
+		if !entry.dir {
+			records[idx].DataSize = entry.size
+			cursor += align16(entry.size)
+		}
+	}
+
+	dataSize := cursor
+
+	var meta bytes.Buffer
+	infoCount := 0
+	addInfo := func(t IdentifierType, values ...uint32) {
+		binary.Write(&meta, binary.BigEndian, InfoHeader{Type: t, Size: int32(4 * len(values))})
+		binary.Write(&meta, binary.BigEndian, values)
+		infoCount++
+	}
+
+	addInfo(IdentifierDRMType, pw.DrmType)
+	addInfo(IdentifierContentType, uint32(pw.ContentType))
+	addInfo(IdentifierPackageFlags, 0)
+	addInfo(IdentifierFileIndexInfo, 0, uint32(recordListSize+int64(names.Len())))
+
+	var sfoOffset int64
+	if sfo != nil {
+		// the SFO goes after its own record (header plus offset and size)
+		sfoOffset = align16(builderInfoOffset + int64(meta.Len()+binary.Size(InfoHeader{})+8))
+		addInfo(IdentifierSFO, uint32(sfoOffset), uint32(len(sfo)))
+	} else {
+		sfoOffset = align16(builderInfoOffset + int64(meta.Len()))
+	}
+
+	dataOffset := align16(sfoOffset + int64(len(sfo)))
+	totalSize := dataOffset + dataSize + fileHashSize
+
+	header := FileHeader{
+		Magic:      fileHeader,
+		Revision:   revision,
+		Type:       pkgType,
+		InfoOffset: builderInfoOffset,
+		InfoCount:  int32(infoCount),
+		HeaderSize: builderInfoOffset,
+		ItemCount:  int32(len(entries)),
+		TotalSize:  totalSize,
+		DataOffset: dataOffset,
+		DataSize:   dataSize,
+		Digest:     pw.Digest,
+		DataIV:     dataIV,
+	}
+	copy(header.ContentID[:], pw.ContentID)
+
+	ext := ExtendedHeader{
+		Magic:       extHeader,
+		Unknown1:    1,
+		HeaderSize:  int32(binary.Size(ExtendedHeader{})),
+		DataOffset:  int32(dataOffset),
+		DataSize:    int32(dataSize),
+		PkgDataSize: dataSize,
+		DataType2:   dataType,
+	}
+
+	rawHeader := bytes.Buffer{}
+	binary.Write(&rawHeader, binary.BigEndian, header)
+	if err := signHeader(&header, rawHeader.Bytes()); err != nil {
+		return err
+	}
+
+	hasher := sha1.New()
+	out := &offsetWriter{w: io.MultiWriter(w, hasher)}
+
+	if err := binary.Write(out, binary.BigEndian, header); err != nil {
+		return err
+	}
+
+	if err := binary.Write(out, binary.BigEndian, ext); err != nil {
+		return err
+	}
+
+	if _, err := out.Write(meta.Bytes()); err != nil {
+		return err
+	}
+
+	if err := out.padTo(sfoOffset); err != nil {
+		return err
+	}
+
+	if _, err := out.Write(sfo); err != nil {
+		return err
+	}
+
+	if err := out.padTo(dataOffset); err != nil {
+		return err
+	}
+
+	// the whole data section uses the pkg key, see entryFlags
+	enc := &offsetWriter{w: cipher.StreamWriter{S: NewCTR(block, dataIV[:], 0), W: out}}
+
+	if err := binary.Write(enc, binary.BigEndian, records); err != nil {
+		return err
+	}
+
+	if _, err := enc.Write(names.Bytes()); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.dir {
+			continue
+		}
+
+		if err := enc.padTo(entry.offset); err != nil {
+			return err
+		}
+
+		if err := copyEntry(enc, fsys, entry); err != nil {
+			return err
+		}
+	}
+
+	if err := enc.padTo(dataSize); err != nil {
+		return err
+	}
+
+	fileHash := make([]byte, fileHashSize)
+	copy(fileHash, hasher.Sum(nil))
+
+	_, err = w.Write(fileHash)
+	return err
+}
+
+// entryFlags returns the item record flags. PSP and PSOne entries are marked
+// with EntryTypePSP so they are encrypted with the pkg key instead of KeyPS3.
+func (pw *Writer) entryFlags(entry *builderEntry) uint32 {
+	var flags uint32
+
+	if entry.dir {
+		flags = uint32(FileTypeDirectory)
+	} else {
+		flags = uint32(FileTypeFile3)
+	}
+
+	switch pw.ContentType {
+	case ContentTypePS1, ContentTypePSP, ContentTypePSPGo, ContentTypeMinis, ContentTypeNeoGeo:
+		flags |= EntryTypePSP << 24
+	}
+
+	return flags
+}
+
+// isPS3Content reports whether the content type is only used by PS3 packages.
+func isPS3Content(t ContentTypeEnum) bool {
+	switch t {
+	case ContentTypeGameData, ContentTypeGameExec, ContentTypeTheme, ContentTypeWidget,
+		ContentTypeLicense, ContentTypeVSHModule, ContentTypeAvatar, ContentTypeVMC, ContentTypePS2:
+		return true
+	default:
+		return false
+	}
+}
+
+// signHeader fills the SHA1 fragment and the CMAC of the header checked by
+// VerifyHeader. The NPDRM signature is left empty.
+func signHeader(header *FileHeader, raw []byte) error {
+	sum := sha1.Sum(raw[:headerDigestSize])
+	copy(header.HeaderSha1Hash[:], sum[len(sum)-8:])
+
+	mac, err := AESCMAC(KeyPS3, raw[:headerDigestSize])
+	if err != nil {
+		return err
+	}
+
+	copy(header.HeaderCmacHash[:], mac)
+
+	return nil
+}
+
+func collectEntries(fsys fs.FS) (entries []builderEntry, sfo []byte, err error) {
+	err = fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if name == "." {
+			return nil
+		}
+
+		if d.IsDir() {
+			entries = append(entries, builderEntry{name: name, dir: true})
+			return nil
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, builderEntry{name: name, size: info.Size()})
+
+		if sfo == nil && isParamSFO(name) {
+			sfo, err = fs.ReadFile(fsys, name)
+		}
+
+		return err
+	})
+
+	return
+}
+
+func isParamSFO(name string) bool {
+	dir := path.Dir(name)
+	return strings.EqualFold(path.Base(name), "param.sfo") && (dir == "." || dir == "sce_sys")
+}
+
+func copyEntry(w io.Writer, fsys fs.FS, entry builderEntry) error {
+	f, err := fsys.Open(entry.name)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	n, err := io.Copy(w, f)
+	if err != nil {
+		return err
+	}
+
+	if n != entry.size {
+		return errors.New("pkg: file size changed while writing: " + entry.name)
+	}
+
+	return nil
+}
+
+func align16(n int64) int64 {
+	return (n + 15) &^ 15
+}
+
+// An offsetWriter keeps track of the number of bytes written so the output
+// can be padded to a given offset.
+type offsetWriter struct {
+	w   io.Writer
+	off int64
+}
+
+func (ow *offsetWriter) Write(b []byte) (int, error) {
+	n, err := ow.w.Write(b)
+	ow.off += int64(n)
+	return n, err
+}
+
+func (ow *offsetWriter) padTo(offset int64) error {
+	if offset < ow.off {
+		return errors.New("pkg: the given offset is behind the current position")
+	}
+
+	_, err := ow.Write(make([]byte, offset-ow.off))
+	return err
+}
@@ -0,0 +1,94 @@
+package pkg
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"testing/fstest"
+)
+
+func readPackage(t *testing.T, data []byte) (*Reader, map[string][]byte) {
+	r, err := NewReader(bytes.NewReader(data), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{}
+	for {
+		entry, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		contents, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !entry.IsDirectory() {
+			files[entry.Name()] = contents
+		}
+	}
+
+	if !r.Valid() {
+		t.Fatal("pkg hash check failed")
+	}
+
+	return r, files
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	fsys := fstest.MapFS{
+		"eboot.bin":         {Data: randomData(100000)},
+		"sce_sys/icon0.png": {Data: []byte("icon")},
+	}
+
+	tests := []struct {
+		name        string
+		contentType ContentTypeEnum
+		keyType     int
+		dataType    uint32
+	}{
+		{"vita", ContentTypeVitaApp, 2, 2},
+		{"ps3", ContentTypeGameExec, 2, 0},
+	}
+
+	for _, tt := range tests {
+		w := NewWriter("UP0000-NPUB00000_00-0000000000000000", tt.contentType, tt.keyType)
+
+		var first, second bytes.Buffer
+		if err := w.WriteFS(&first, fsys); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := w.WriteFS(&second, fsys); err != nil {
+			t.Fatal(err)
+		}
+
+		if w.DataIV != [16]byte{} {
+			t.Errorf("%s: the generated IV was stored in the writer", tt.name)
+		}
+
+		r1, files := readPackage(t, first.Bytes())
+		r2, _ := readPackage(t, second.Bytes())
+
+		if r1.FileHeader.DataIV == r2.FileHeader.DataIV {
+			t.Errorf("%s: both packages use the same IV", tt.name)
+		}
+
+		if r1.extendedHeader.DataType2 != tt.dataType {
+			t.Errorf("%s: data type %d, want %d", tt.name, r1.extendedHeader.DataType2, tt.dataType)
+		}
+
+		for name, file := range fsys {
+			if !bytes.Equal(files[name], file.Data) {
+				t.Errorf("%s: %s doesn't match", tt.name, name)
+			}
+		}
+	}
+}
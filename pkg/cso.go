@@ -0,0 +1,378 @@
+package pkg
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+type CSOFormat int
+
+const (
+	// CSOFormatCSO compresses the blocks with deflate
+	CSOFormatCSO CSOFormat = iota
+	// CSOFormatZSO compresses the blocks with LZ4
+	CSOFormatZSO
+)
+
+const (
+	csoHeaderSize = 0x18
+	// index flag of the blocks stored without compression
+	csoPlainBlock = 0x80000000
+)
+
+type csoHeader struct {
+	Magic      [4]byte
+	HeaderSize uint32
+	TotalBytes uint64
+	BlockSize  uint32
+	Version    uint8
+	Align      uint8
+	_          [2]byte
+}
+
+// CSOOptions configures a CSOWriter.
+type CSOOptions struct {
+	Format CSOFormat
+	// BlockSize of the uncompressed blocks, defaults to 2048
+	BlockSize uint32
+	// Level is the deflate compression level (zero uses the default), it's
+	// ignored by ZSO
+	Level int
+	// Align is the shift applied to the block offsets, needed for images
+	// whose compressed size is bigger than 2GiB
+	Align uint8
+}
+
+// A CSOWriter compresses an ISO image into the CSO or ZSO formats. Since the
+// block index precedes the data the total size of the image has to be known
+// in advance, and the index is written on Close.
+type CSOWriter struct {
+	w io.Writer
+	// seeker is set when the index can be written in place, otherwise the
+	// compressed blocks are kept in pending until Close
+	seeker  io.WriteSeeker
+	pending bytes.Buffer
+	header  csoHeader
+	opts    CSOOptions
+	index   []uint32
+	buf     []byte
+	scratch bytes.Buffer
+	flate   *flate.Writer
+	start   int64
+	pos     int64
+	total   int64
+	written int64
+}
+
+// NewCSOWriter creates the writer of an image of totalSize bytes. A nil opts
+// uses the default CSO options. If w is a seekable io.WriteSeeker the header
+// is written and the index reserved right away, otherwise the whole image is
+// kept in memory and written on Close.
+func NewCSOWriter(w io.Writer, totalSize int64, opts *CSOOptions) (*CSOWriter, error) {
+	cw := &CSOWriter{w: w, total: totalSize}
+	if opts != nil {
+		cw.opts = *opts
+	}
+
+	if cw.opts.BlockSize == 0 {
+		cw.opts.BlockSize = isoSectorSize
+	}
+
+	if cw.opts.Level == 0 {
+		cw.opts.Level = flate.DefaultCompression
+	}
+
+	if cw.opts.Format == CSOFormatCSO {
+		fw, err := flate.NewWriter(&cw.scratch, cw.opts.Level)
+		if err != nil {
+			return nil, err
+		}
+
+		cw.flate = fw
+	}
+
+	numBlocks := (totalSize + int64(cw.opts.BlockSize) - 1) / int64(cw.opts.BlockSize)
+	cw.index = make([]uint32, 0, numBlocks+1)
+	cw.buf = make([]byte, 0, cw.opts.BlockSize)
+
+	cw.pos = csoHeaderSize + (numBlocks+1)*4
+
+	cw.header = csoHeader{
+		HeaderSize: csoHeaderSize,
+		TotalBytes: uint64(totalSize),
+		BlockSize:  cw.opts.BlockSize,
+		Version:    1,
+		Align:      cw.opts.Align,
+	}
+
+	if cw.opts.Format == CSOFormatZSO {
+		copy(cw.header.Magic[:], "ZISO")
+	} else {
+		copy(cw.header.Magic[:], "CISO")
+	}
+
+	// pipes are io.WriteSeekers too, but fail to seek
+	ws, ok := w.(io.WriteSeeker)
+	if !ok {
+		return cw, nil
+	}
+
+	start, err := ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return cw, nil
+	}
+
+	cw.seeker = ws
+	cw.start = start
+
+	if err := binary.Write(w, binary.LittleEndian, cw.header); err != nil {
+		return nil, err
+	}
+
+	// reserve the index, filled on Close
+	if _, err := w.Write(make([]byte, (numBlocks+1)*4)); err != nil {
+		return nil, err
+	}
+
+	return cw, nil
+}
+
+// out returns where the compressed blocks are written.
+func (cw *CSOWriter) out() io.Writer {
+	if cw.seeker == nil {
+		return &cw.pending
+	}
+
+	return cw.w
+}
+
+func (cw *CSOWriter) Write(p []byte) (n int, err error) {
+	if cw.written+int64(len(p)) > cw.total {
+		return 0, errors.New("pkg: write past the CSO image size")
+	}
+
+	for len(p) > 0 {
+		count := copy(cw.buf[len(cw.buf):cap(cw.buf)], p)
+		cw.buf = cw.buf[:len(cw.buf)+count]
+		p = p[count:]
+		n += count
+		cw.written += int64(count)
+
+		if len(cw.buf) == cap(cw.buf) {
+			if err = cw.writeBlock(); err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+func (cw *CSOWriter) writeBlock() error {
+	if err := cw.alignBlock(); err != nil {
+		return err
+	}
+
+	data, err := cw.compress(cw.buf)
+	if err != nil {
+		return err
+	}
+
+	entry := uint32(cw.pos >> cw.opts.Align)
+	if data == nil || len(data) >= len(cw.buf) {
+		data = cw.buf
+		entry |= csoPlainBlock
+	}
+
+	if cw.pos>>cw.opts.Align >= csoPlainBlock {
+		return errors.New("pkg: CSO image too big, increase the alignment")
+	}
+
+	if _, err := cw.out().Write(data); err != nil {
+		return err
+	}
+
+	cw.index = append(cw.index, entry)
+	cw.pos += int64(len(data))
+	cw.buf = cw.buf[:0]
+
+	return nil
+}
+
+// alignBlock pads the output so the next block starts at an aligned offset.
+func (cw *CSOWriter) alignBlock() error {
+	mask := int64(1)<<cw.opts.Align - 1
+	pad := (cw.pos+mask)&^mask - cw.pos
+	if pad == 0 {
+		return nil
+	}
+
+	if _, err := cw.out().Write(make([]byte, pad)); err != nil {
+		return err
+	}
+
+	cw.pos += pad
+
+	return nil
+}
+
+// compress returns the compressed block, nil if it can't be compressed.
+func (cw *CSOWriter) compress(block []byte) ([]byte, error) {
+	if cw.opts.Format == CSOFormatZSO {
+		return lz4CompressBlock(block), nil
+	}
+
+	cw.scratch.Reset()
+	cw.flate.Reset(&cw.scratch)
+
+	if _, err := cw.flate.Write(block); err != nil {
+		return nil, err
+	}
+
+	if err := cw.flate.Close(); err != nil {
+		return nil, err
+	}
+
+	return cw.scratch.Bytes(), nil
+}
+
+// Close flushes the last block and writes the block index.
+func (cw *CSOWriter) Close() error {
+	if cw.written != cw.total {
+		return errors.New("pkg: CSO image is incomplete")
+	}
+
+	if len(cw.buf) > 0 {
+		if err := cw.writeBlock(); err != nil {
+			return err
+		}
+	}
+
+	if err := cw.alignBlock(); err != nil {
+		return err
+	}
+
+	// the last entry marks the end of the data
+	cw.index = append(cw.index, uint32(cw.pos>>cw.opts.Align))
+
+	if cw.seeker == nil {
+		if err := binary.Write(cw.w, binary.LittleEndian, cw.header); err != nil {
+			return err
+		}
+
+		if err := binary.Write(cw.w, binary.LittleEndian, cw.index); err != nil {
+			return err
+		}
+
+		_, err := cw.pending.WriteTo(cw.w)
+		return err
+	}
+
+	if _, err := cw.seeker.Seek(cw.start+csoHeaderSize, io.SeekStart); err != nil {
+		return err
+	}
+
+	if err := binary.Write(cw.w, binary.LittleEndian, cw.index); err != nil {
+		return err
+	}
+
+	_, err := cw.seeker.Seek(cw.start+cw.pos, io.SeekStart)
+	return err
+}
+
+const (
+	lz4MinMatch     = 4
+	lz4HashLog      = 16
+	lz4MaxOffset    = 65535
+	lz4LastLiterals = 5
+	lz4MFLimit      = 12
+)
+
+// lz4CompressBlock compresses src with the LZ4 block format (no frame). It
+// returns nil if the data can't be compressed.
+func lz4CompressBlock(src []byte) []byte {
+	if len(src) < lz4MFLimit+1 {
+		return nil
+	}
+
+	var table [1 << lz4HashLog]int32
+	dst := make([]byte, 0, len(src))
+	anchor := 0
+	limit := len(src) - lz4MFLimit
+
+	for pos := 0; pos < limit; {
+		seq := binary.LittleEndian.Uint32(src[pos:])
+		h := (seq * 2654435761) >> (32 - lz4HashLog)
+		ref := int(table[h]) - 1
+		table[h] = int32(pos + 1)
+
+		if ref < 0 || pos-ref > lz4MaxOffset || binary.LittleEndian.Uint32(src[ref:]) != seq {
+			pos++
+			continue
+		}
+
+		// extend the match, keeping the last literals out of it
+		length := lz4MinMatch
+		for pos+length < len(src)-lz4LastLiterals && src[ref+length] == src[pos+length] {
+			length++
+		}
+
+		dst = lz4AppendSequence(dst, src[anchor:pos], pos-ref, length)
+		if len(dst) >= len(src) {
+			return nil
+		}
+
+		pos += length
+		anchor = pos
+	}
+
+	dst = lz4AppendSequence(dst, src[anchor:], 0, 0)
+	if len(dst) >= len(src) {
+		return nil
+	}
+
+	return dst
+}
+
+// lz4AppendSequence appends the literals and the match (if length > 0).
+func lz4AppendSequence(dst, literals []byte, offset, length int) []byte {
+	token := len(dst)
+	dst = append(dst, 0)
+
+	if len(literals) >= 15 {
+		dst[token] = 15 << 4
+		dst = lz4AppendLength(dst, len(literals)-15)
+	} else {
+		dst[token] = byte(len(literals) << 4)
+	}
+
+	dst = append(dst, literals...)
+
+	if length == 0 {
+		return dst
+	}
+
+	dst = append(dst, byte(offset), byte(offset>>8))
+
+	matchLength := length - lz4MinMatch
+	if matchLength >= 15 {
+		dst[token] |= 15
+		dst = lz4AppendLength(dst, matchLength-15)
+	} else {
+		dst[token] |= byte(matchLength)
+	}
+
+	return dst
+}
+
+func lz4AppendLength(dst []byte, n int) []byte {
+	for n >= 255 {
+		dst = append(dst, 255)
+		n -= 255
+	}
+
+	return append(dst, byte(n))
+}
@@ -0,0 +1,255 @@
+package pkg
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+// lz4DecompressBlock decodes an LZ4 block until size bytes are produced, the
+// aligned blocks are followed by padding.
+func lz4DecompressBlock(t *testing.T, src []byte, size int) []byte {
+	var dst []byte
+
+	for pos := 0; len(dst) < size; {
+		token := src[pos]
+		pos++
+
+		length := func(n int) int {
+			if n == 15 {
+				for {
+					b := src[pos]
+					pos++
+					n += int(b)
+					if b != 255 {
+						break
+					}
+				}
+			}
+
+			return n
+		}
+
+		literals := length(int(token >> 4))
+		dst = append(dst, src[pos:pos+literals]...)
+		pos += literals
+
+		if len(dst) >= size {
+			break
+		}
+
+		offset := int(binary.LittleEndian.Uint16(src[pos:]))
+		pos += 2
+
+		if offset == 0 || offset > len(dst) {
+			t.Fatalf("invalid LZ4 offset %d", offset)
+		}
+
+		matchLength := length(int(token&15)) + lz4MinMatch
+		for i := 0; i < matchLength; i++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+
+	return dst
+}
+
+// readCSO decompresses a CSO or ZSO image.
+func readCSO(t *testing.T, data []byte) []byte {
+	var header csoHeader
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &header); err != nil {
+		t.Fatal(err)
+	}
+
+	blockSize := int(header.BlockSize)
+	numBlocks := (int(header.TotalBytes) + blockSize - 1) / blockSize
+	index := make([]uint32, numBlocks+1)
+	binary.Read(bytes.NewReader(data[csoHeaderSize:]), binary.LittleEndian, index)
+
+	var out []byte
+	for idx := 0; idx < numBlocks; idx++ {
+		start := int(index[idx]&^csoPlainBlock) << header.Align
+		end := int(index[idx+1]&^csoPlainBlock) << header.Align
+
+		size := int(header.TotalBytes) - len(out)
+		if size > blockSize {
+			size = blockSize
+		}
+
+		switch {
+		case index[idx]&csoPlainBlock != 0:
+			out = append(out, data[start:start+size]...)
+		case string(header.Magic[:]) == "ZISO":
+			out = append(out, lz4DecompressBlock(t, data[start:end], size)...)
+		default:
+			block, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(data[start:end])))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			out = append(out, block...)
+		}
+	}
+
+	return out
+}
+
+// writeSeeker is an in-memory io.WriteSeeker.
+type writeSeeker struct {
+	buf []byte
+	pos int64
+}
+
+func (ws *writeSeeker) Write(p []byte) (int, error) {
+	if end := ws.pos + int64(len(p)); end > int64(len(ws.buf)) {
+		ws.buf = append(ws.buf, make([]byte, end-int64(len(ws.buf)))...)
+	}
+
+	copy(ws.buf[ws.pos:], p)
+	ws.pos += int64(len(p))
+
+	return len(p), nil
+}
+
+func (ws *writeSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		ws.pos = offset
+	case io.SeekCurrent:
+		ws.pos += offset
+	case io.SeekEnd:
+		ws.pos = int64(len(ws.buf)) + offset
+	}
+
+	return ws.pos, nil
+}
+
+// writeCSOImage compresses iso into w.
+func writeCSOImage(t *testing.T, w io.Writer, iso []byte, opts *CSOOptions) {
+	cw, err := NewCSOWriter(w, int64(len(iso)), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// odd writes cross the blocks
+	for pos := 0; pos < len(iso); pos += 1000 {
+		end := pos + 1000
+		if end > len(iso) {
+			end = len(iso)
+		}
+
+		if _, err := cw.Write(iso[pos:end]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCSOWriter(t *testing.T) {
+	// compressible sectors, random ones and a partial last block
+	iso := testText(20*isoSectorSize + 100)
+	copy(iso[5*isoSectorSize:], randomData(3*isoSectorSize))
+
+	tests := []struct {
+		name string
+		opts CSOOptions
+		// magic of the image
+		magic string
+	}{
+		{"cso", CSOOptions{Format: CSOFormatCSO}, "CISO"},
+		{"cso aligned", CSOOptions{Format: CSOFormatCSO, Align: 4}, "CISO"},
+		{"cso large blocks", CSOOptions{Format: CSOFormatCSO, BlockSize: 4 * isoSectorSize, Level: flate.BestCompression}, "CISO"},
+		{"zso", CSOOptions{Format: CSOFormatZSO}, "ZISO"},
+		{"zso aligned", CSOOptions{Format: CSOFormatZSO, Align: 3}, "ZISO"},
+	}
+
+	for _, tt := range tests {
+		ws := &writeSeeker{}
+		writeCSOImage(t, ws, iso, &tt.opts)
+
+		// a plain writer gets the same image, written on Close
+		var buf bytes.Buffer
+		writeCSOImage(t, &buf, iso, &tt.opts)
+
+		if !bytes.Equal(buf.Bytes(), ws.buf) {
+			t.Fatalf("%s: the images of the plain and the seekable writers differ", tt.name)
+		}
+
+		if string(ws.buf[:4]) != tt.magic {
+			t.Fatalf("%s: wrong magic %q", tt.name, ws.buf[:4])
+		}
+
+		if len(ws.buf) >= len(iso) {
+			t.Errorf("%s: the image wasn't compressed", tt.name)
+		}
+
+		if !bytes.Equal(readCSO(t, ws.buf), iso) {
+			t.Fatalf("%s: the decompressed image doesn't match", tt.name)
+		}
+	}
+}
+
+func TestCSOWriterIncomplete(t *testing.T) {
+	cw, err := NewCSOWriter(&writeSeeker{}, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cw.Write(make([]byte, 11)); err == nil {
+		t.Fatal("wrote past the image size")
+	}
+
+	cw.Write(make([]byte, 5))
+	if err := cw.Close(); err == nil {
+		t.Fatal("closed an incomplete image")
+	}
+}
+
+func TestUnpackPSPImage(t *testing.T) {
+	iso := testText(40 * isoSectorSize)
+	fsys := fstest.MapFS{
+		"USRDIR/CONTENT/EBOOT.PBP": {Data: testPBP(testNPUMDIMG(t, iso, 16))},
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter("UP9000-NPUH00000_00-0000000000000000", ContentTypePSP, 1)
+	if err := w.WriteFS(&buf, fsys); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, output := range []PSPOutputMode{PSPOutputCSO, PSPOutputZSO} {
+		r, err := NewReader(bytes.NewReader(buf.Bytes()), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.PSPOutput = output
+
+		dir := t.TempDir()
+		if err := r.Unpack(dir); err != nil {
+			t.Fatal(err)
+		}
+
+		ext := "cso"
+		if output == PSPOutputZSO {
+			ext = "zso"
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, "pspemu/ISO/NPUH00000."+ext))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(readCSO(t, data), iso) {
+			t.Fatalf("the %s image doesn't match", ext)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package pkg
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+)
+
+// debugCipher implements the SHA1 keystream of debug packages as a block
+// cipher, so it can be used in CTR mode like the retail keys. The keystream
+// block n is the SHA1 of a context made from the header digest and n.
+type debugCipher struct {
+	context [0x40]byte
+	iv      uint64
+}
+
+// newDebugCipher creates the keystream of a debug package. The iv is the one
+// given to NewCTR, only used to recover the block number from the counter.
+func newDebugCipher(digest, iv []byte) *debugCipher {
+	c := &debugCipher{iv: binary.BigEndian.Uint64(iv[8:])}
+
+	copy(c.context[0x00:], digest[:8])
+	copy(c.context[0x08:], digest[:8])
+	copy(c.context[0x10:], digest[8:16])
+	copy(c.context[0x18:], digest[8:16])
+
+	return c
+}
+
+func (c *debugCipher) BlockSize() int {
+	return 16
+}
+
+func (c *debugCipher) Encrypt(dst, src []byte) {
+	context := c.context
+	binary.BigEndian.PutUint64(context[0x38:], binary.BigEndian.Uint64(src[8:])-c.iv)
+
+	sum := sha1.Sum(context[:])
+	copy(dst, sum[:c.BlockSize()])
+}
+
+// Decrypt is the same as Encrypt, the cipher is only used in CTR mode.
+func (c *debugCipher) Decrypt(dst, src []byte) {
+	c.Encrypt(dst, src)
+}
@@ -0,0 +1,93 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"io/ioutil"
+	"testing"
+	"testing/fstest"
+)
+
+func TestDebugCipher(t *testing.T) {
+	digest := make([]byte, 16)
+	for i := range digest {
+		digest[i] = byte(i)
+	}
+
+	// the low word of the IV wraps around, the block number doesn't
+	iv := []byte{
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+	}
+
+	// SHA1 of the context with the block number, calculated with hashlib
+	tests := []struct {
+		block     int
+		keystream []byte
+	}{
+		{0, []byte{
+			0xb8, 0x86, 0x31, 0x8b, 0xa1, 0x0b, 0x52, 0xde,
+			0x6c, 0x7b, 0x57, 0x17, 0x9a, 0x1e, 0x48, 0x31,
+		}},
+		{1, []byte{
+			0xe9, 0x91, 0xcb, 0x3a, 0xf2, 0x58, 0xe5, 0x3c,
+			0xa9, 0x0f, 0x0c, 0xf4, 0x2d, 0x0a, 0x33, 0x23,
+		}},
+		{0x1234, []byte{
+			0x67, 0xb9, 0x7c, 0xe6, 0x34, 0x05, 0x97, 0xcd,
+			0xd7, 0xd9, 0x13, 0x62, 0xcc, 0x1c, 0xbe, 0x79,
+		}},
+	}
+
+	keystream := make([]byte, 0x1235*16)
+	cipher.NewCTR(newDebugCipher(digest, iv), iv).XORKeyStream(keystream, keystream)
+
+	for _, tt := range tests {
+		if block := keystream[tt.block*16 : tt.block*16+16]; !bytes.Equal(block, tt.keystream) {
+			t.Errorf("block %#x: got %x, want %x", tt.block, block, tt.keystream)
+		}
+	}
+}
+
+func TestDebugPackage(t *testing.T) {
+	eboot := randomData(5000)
+	fsys := fstest.MapFS{"eboot.bin": {Data: eboot}}
+
+	var buf bytes.Buffer
+	w := NewWriter(testContentID, ContentTypeVitaApp, 2)
+	w.Debug = true
+	copy(w.Digest[:], randomData(16))
+	if err := w.WriteFS(&buf, fsys); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !r.FileHeader.IsDebug() || r.FileHeader.Digest != w.Digest {
+		t.Fatal("not a debug package")
+	}
+
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil || !bytes.Equal(data, eboot) {
+		t.Fatalf("eboot.bin doesn't match: %v", err)
+	}
+
+	ra := openTestPackage(t, buf.Bytes())
+	entry, _ := ra.Lookup("eboot.bin")
+	sr, err := ra.OpenEntry(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data = make([]byte, 100)
+	if _, err := sr.ReadAt(data, 1001); err != nil || !bytes.Equal(data, eboot[1001:1101]) {
+		t.Fatalf("random access to eboot.bin doesn't match: %v", err)
+	}
+}
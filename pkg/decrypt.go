@@ -0,0 +1,155 @@
+package pkg
+
+import (
+	"crypto/cipher"
+	"errors"
+	"io"
+	"sort"
+)
+
+// keyRange is a region of the data section encrypted with an entry key.
+type keyRange struct {
+	start int64
+	end   int64
+	key   cipher.Block
+}
+
+// keyRanges returns the regions of the data section encrypted with the keys
+// selected by readFileIndex, sorted by offset. The rest uses the pkg key.
+func (pr *Reader) keyRanges() []keyRange {
+	var ranges []keyRange
+
+	for _, entry := range pr.index.itemRecords {
+		ranges = append(ranges, keyRange{
+			start: entry.nameOffset,
+			end:   entry.nameOffset + align16(int64(len(entry.name))),
+			key:   entry.key,
+		})
+
+		if !entry.IsDirectory() && entry.size > 0 {
+			ranges = append(ranges, keyRange{
+				start: entry.offset,
+				end:   entry.offset + align16(entry.size),
+				key:   entry.key,
+			})
+		}
+	}
+
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].start < ranges[j].start
+	})
+
+	return ranges
+}
+
+// keyAt returns the key used at the given position of the data section and
+// the position where the region using it ends.
+func (pr *Reader) keyAt(ranges []keyRange, pos int64) (cipher.Block, int64) {
+	end := pr.FileHeader.DataSize
+
+	idx := sort.Search(len(ranges), func(i int) bool {
+		return ranges[i].end > pos
+	})
+
+	if idx < len(ranges) {
+		if ranges[idx].start <= pos {
+			return ranges[idx].key, ranges[idx].end
+		}
+
+		end = ranges[idx].start
+	}
+
+	return pr.aesReader.block, end
+}
+
+// decryptAt decrypts in place the data found at the given position of the
+// data section.
+func (pr *Reader) decryptAt(b []byte, pos int64, ranges []keyRange) {
+	for len(b) > 0 {
+		key, end := pr.keyAt(ranges, pos)
+
+		n := int64(len(b))
+		if end-pos < n {
+			n = end - pos
+		}
+
+		stream := NewCTR(key, pr.FileHeader.DataIV[:], pos/16)
+		if skew := pos % 16; skew > 0 {
+			// drop the keystream bytes before the requested position
+			discard := make([]byte, skew)
+			stream.XORKeyStream(discard, discard)
+		}
+
+		stream.XORKeyStream(b[:n], b[:n])
+		b = b[n:]
+		pos += n
+	}
+}
+
+// WriteDecrypted writes a copy of the pkg with the data section decrypted in
+// place, so every offset of the original file is kept. It reads the whole
+// package, so it has to be called instead of Next.
+func (pr *Reader) WriteDecrypted(w io.Writer) error {
+	if pr.index.idx > 0 || pr.current != nil {
+		return errors.New("pkg: WriteDecrypted must be called before Next")
+	}
+
+	// the headers and the file index were already read into head.bin
+	head := pr.headBuffer.Bytes()
+	dataOffset := pr.FileHeader.DataOffset
+	if int64(len(head)) < dataOffset {
+		return errors.New("pkg: incomplete pkg header")
+	}
+
+	if _, err := w.Write(head[:dataOffset]); err != nil {
+		return err
+	}
+
+	ranges := pr.keyRanges()
+
+	index := dup(head[dataOffset:])
+	pr.decryptAt(index, 0, ranges)
+
+	if _, err := w.Write(index); err != nil {
+		return err
+	}
+
+	pos := int64(len(index))
+	raw := pr.aesReader.RawReader()
+	buf := make([]byte, 64*1024)
+
+	for pos < pr.FileHeader.DataSize {
+		n := int64(len(buf))
+		if remaining := pr.FileHeader.DataSize - pos; remaining < n {
+			n = remaining
+		}
+
+		if _, err := io.ReadFull(raw, buf[:n]); err != nil {
+			return err
+		}
+
+		pr.decryptAt(buf[:n], pos, ranges)
+
+		if _, err := w.Write(buf[:n]); err != nil {
+			return err
+		}
+
+		pos += n
+	}
+
+	pr.index.idx = len(pr.index.itemRecords)
+	if err := pr.readTail(); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(pr.tailBuffer.Bytes()); err != nil {
+		return err
+	}
+
+	// nothing else can be read from the package
+	pr.err = io.EOF
+
+	// anything after the pkg hash is copied as is
+	_, err := io.Copy(w, pr.rawReader)
+	return err
+}
@@ -0,0 +1,76 @@
+package pkg
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteDecrypted(t *testing.T) {
+	data := readTestdata(t, testVitaPkg)
+
+	r, err := NewReader(bytes.NewReader(data), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := r.WriteDecrypted(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if !r.Valid() {
+		t.Fatal("the SHA1 of the package doesn't match")
+	}
+
+	out := buf.Bytes()
+	if len(out) != len(data) {
+		t.Fatalf("got %d bytes, want %d", len(out), len(data))
+	}
+
+	// the headers and the tail are kept, the data section is decrypted in
+	// place
+	dataOffset := r.FileHeader.DataOffset
+	dataEnd := dataOffset + r.FileHeader.DataSize
+	if !bytes.Equal(out[:dataOffset], data[:dataOffset]) || !bytes.Equal(out[dataEnd:], data[dataEnd:]) {
+		t.Fatal("the headers or the tail were changed")
+	}
+
+	expected := map[string][]byte{
+		"eboot.bin":         randomData(testEbootSize),
+		"sce_sys/icon0.png": []byte(testIconData),
+	}
+
+	for _, entry := range r.Entries() {
+		offset := dataOffset + entry.nameOffset
+		if name := string(out[offset : offset+int64(len(entry.name))]); name != entry.name {
+			t.Errorf("got name %q, want %q", name, entry.name)
+		}
+
+		if contents, ok := expected[entry.name]; ok {
+			offset = dataOffset + entry.offset
+			if !bytes.Equal(out[offset:offset+entry.size], contents) {
+				t.Errorf("%s wasn't decrypted", entry.name)
+			}
+		}
+	}
+
+	if _, err := r.Next(); err == nil {
+		t.Fatal("read an entry after WriteDecrypted")
+	}
+}
+
+func TestWriteDecryptedAfterNext(t *testing.T) {
+	r, err := NewReader(bytes.NewReader(readTestdata(t, testVitaPkg)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := r.WriteDecrypted(&buf); err == nil {
+		t.Fatal("WriteDecrypted after Next didn't fail")
+	}
+}
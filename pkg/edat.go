@@ -0,0 +1,374 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var npdMagic = [4]byte{'N', 'P', 'D', 0}
+
+const (
+	EDATFlagCompressed   = 0x00000001
+	EDATFlagPlainData    = 0x00000002
+	EDATFlagEncryptedKey = 0x00000008
+	EDATFlagHMACHash     = 0x00000010
+	EDATFlagBlockHeaders = 0x00000020
+	EDATFlagSDAT         = 0x01000000
+	EDATFlagDebug        = 0x80000000
+)
+
+const (
+	// the block metadata starts after the NPD and EDAT headers, the header
+	// hashes and their signatures
+	edatMetadataOffset = 0x100
+	// maximum block size accepted, the PS3 tools use up to 32KiB
+	edatMaxBlockSize = 0x100000
+)
+
+var (
+	// ErrEDATLicense is returned when opening an EDAT without its klicensee.
+	ErrEDATLicense = errors.New("pkg: EDAT files need a RAP or RIF license")
+	// ErrEDATHash is returned when the hash of an EDAT block doesn't match.
+	ErrEDATHash = errors.New("pkg: EDAT block hash mismatch")
+)
+
+type NPDHeader struct {
+	Magic        [4]byte
+	Version      uint32
+	License      uint32
+	Type         uint32
+	ContentID    [0x30]byte
+	Digest       [0x10]byte
+	TitleHash    [0x10]byte
+	DevHash      [0x10]byte
+	ActivateTime uint64
+	ExpireTime   uint64
+}
+
+type EDATHeader struct {
+	Flags     uint32
+	BlockSize uint32
+	FileSize  uint64
+}
+
+// An EDAT is a PS3 EDAT or SDAT file, the NPDRM encrypted data files.
+type EDAT struct {
+	NPD    NPDHeader
+	Header EDATHeader
+	r      io.ReaderAt
+	key    []byte
+}
+
+// IsEDAT reports whether the data starts with the NPD magic.
+func IsEDAT(header []byte) bool {
+	return bytes.HasPrefix(header, npdMagic[:])
+}
+
+// OpenEDAT reads the headers of an EDAT or SDAT file. The klicensee is only
+// needed by EDAT files, see EDATKlicensee.
+func OpenEDAT(r io.ReaderAt, klicensee []byte) (*EDAT, error) {
+	e := &EDAT{r: r}
+
+	sr := io.NewSectionReader(r, 0, edatMetadataOffset)
+	if err := binary.Read(sr, binary.BigEndian, &e.NPD); err != nil {
+		return nil, err
+	}
+
+	if e.NPD.Magic != npdMagic {
+		return nil, errors.New("invalid NPD header")
+	}
+
+	if e.NPD.Version > 4 {
+		return nil, errors.New("unsupported NPD version")
+	}
+
+	if err := binary.Read(sr, binary.BigEndian, &e.Header); err != nil {
+		return nil, err
+	}
+
+	if e.Header.BlockSize == 0 || e.Header.BlockSize > edatMaxBlockSize {
+		return nil, errors.New("invalid EDAT block size")
+	}
+
+	if e.IsSDAT() {
+		// SDAT files carry their own key
+		e.key = make([]byte, aes.BlockSize)
+		xorBytes(e.key, e.NPD.DevHash[:], KeySDAT)
+	} else if len(klicensee) == aes.BlockSize {
+		e.key = dup(klicensee)
+	} else {
+		return nil, ErrEDATLicense
+	}
+
+	return e, nil
+}
+
+func (e *EDAT) IsSDAT() bool {
+	return e.Header.Flags&EDATFlagSDAT != 0
+}
+
+func (e *EDAT) GetContentID() string {
+	return string(bytes.TrimRight(e.NPD.ContentID[:], "\x00"))
+}
+
+// Size returns the size of the decrypted file.
+func (e *EDAT) Size() int64 {
+	return int64(e.Header.FileSize)
+}
+
+func (e *EDAT) numBlocks() int64 {
+	blockSize := int64(e.Header.BlockSize)
+	return (e.Size() + blockSize - 1) / blockSize
+}
+
+func (e *EDAT) metadataSize() int64 {
+	if e.Header.Flags&(EDATFlagCompressed|EDATFlagBlockHeaders) != 0 {
+		return 0x20
+	}
+
+	return 0x10
+}
+
+// blockSize returns the size of the decrypted block.
+func (e *EDAT) blockSize(idx int64) int64 {
+	size := int64(e.Header.BlockSize)
+	if idx == e.numBlocks()-1 && e.Size()%size != 0 {
+		size = e.Size() % size
+	}
+
+	return size
+}
+
+// edatBlock is the location of an encrypted block and its expected hash.
+type edatBlock struct {
+	offset     int64
+	length     int64
+	compressed bool
+	hash       []byte
+}
+
+func (e *EDAT) readBlockInfo(idx int64) (*edatBlock, error) {
+	metaSize := e.metadataSize()
+	block := &edatBlock{length: e.blockSize(idx)}
+
+	switch {
+	case e.Header.Flags&EDATFlagCompressed != 0:
+		meta := make([]byte, metaSize)
+		if _, err := e.r.ReadAt(meta, edatMetadataOffset+idx*metaSize); err != nil {
+			return nil, err
+		}
+
+		block.hash = meta[:0x10]
+
+		// the location of the block is obfuscated since NPD version 2
+		info := meta[0x10:]
+		if e.NPD.Version > 1 {
+			info = make([]byte, 0x10)
+			for i := 0; i < 4; i++ {
+				info[i] = meta[0xc+i] ^ meta[0x8+i] ^ meta[0x10+i]
+				info[0x4+i] = meta[0x4+i] ^ meta[0x8+i] ^ meta[0x14+i]
+				info[0x8+i] = meta[0xc+i] ^ meta[i] ^ meta[0x18+i]
+				info[0xc+i] = meta[0x4+i] ^ meta[i] ^ meta[0x1c+i]
+			}
+		}
+
+		block.offset = int64(binary.BigEndian.Uint64(info))
+		block.length = int64(binary.BigEndian.Uint32(info[0x8:]))
+		block.compressed = binary.BigEndian.Uint32(info[0xc:]) != 0
+	case e.Header.Flags&EDATFlagBlockHeaders != 0:
+		// the metadata precedes every block
+		offset := edatMetadataOffset + idx*(metaSize+int64(e.Header.BlockSize))
+		meta := make([]byte, metaSize)
+		if _, err := e.r.ReadAt(meta, offset); err != nil {
+			return nil, err
+		}
+
+		block.hash = make([]byte, 0x10)
+		xorBytes(block.hash, meta[:0x10], meta[0x10:])
+		block.offset = offset + metaSize
+	default:
+		block.hash = make([]byte, 0x10)
+		if _, err := e.r.ReadAt(block.hash, edatMetadataOffset+idx*metaSize); err != nil {
+			return nil, err
+		}
+
+		block.offset = edatMetadataOffset + e.numBlocks()*metaSize + idx*int64(e.Header.BlockSize)
+	}
+
+	if block.length <= 0 || block.length > edatMaxBlockSize {
+		return nil, errors.New("invalid EDAT block length")
+	}
+
+	return block, nil
+}
+
+// blockKeys returns the AES key and the hash key of a block.
+func (e *EDAT) blockKeys(idx int64) (key, hashKey []byte, err error) {
+	// the block key is the device hash with the block number at the end
+	blockKey := make([]byte, aes.BlockSize)
+	if e.NPD.Version > 1 {
+		copy(blockKey, e.NPD.DevHash[:0xc])
+	}
+
+	binary.BigEndian.PutUint32(blockKey[0xc:], uint32(idx))
+
+	key = make([]byte, aes.BlockSize)
+	if err = AESECBEncrypt(key, blockKey, e.key); err != nil {
+		return
+	}
+
+	hashKey = key
+	if e.Header.Flags&EDATFlagHMACHash != 0 {
+		hashKey = make([]byte, aes.BlockSize)
+		if err = AESECBEncrypt(hashKey, key, e.key); err != nil {
+			return
+		}
+	}
+
+	if e.Header.Flags&EDATFlagEncryptedKey != 0 {
+		// both keys are encrypted again with the EDAT key
+		edatKey := KeyEDAT0
+		if e.NPD.Version == 4 {
+			edatKey = KeyEDAT1
+		}
+
+		if key, err = aesCBCDecryptBlock(edatKey, key); err != nil {
+			return
+		}
+
+		if hashKey, err = aesCBCDecryptBlock(edatKey, hashKey); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// checkHash verifies the hash of the encrypted block.
+func (e *EDAT) checkHash(data, hashKey, expected []byte) error {
+	var sum []byte
+
+	if e.Header.Flags&EDATFlagHMACHash == 0 {
+		mac, err := AESCMAC(hashKey, data)
+		if err != nil {
+			return err
+		}
+
+		sum = mac
+	} else {
+		mac := hmac.New(sha1.New, hashKey)
+		mac.Write(data)
+		sum = mac.Sum(nil)
+	}
+
+	if !hmac.Equal(sum[:len(expected)], expected) {
+		return ErrEDATHash
+	}
+
+	return nil
+}
+
+// readBlock returns the decrypted and decompressed block.
+func (e *EDAT) readBlock(idx int64) ([]byte, error) {
+	block, err := e.readBlockInfo(idx)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, align16(block.length))
+	if _, err := e.r.ReadAt(data, block.offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if e.Header.Flags&EDATFlagDebug == 0 {
+		key, hashKey, err := e.blockKeys(idx)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := e.checkHash(data, hashKey, block.hash); err != nil {
+			return nil, err
+		}
+
+		if e.Header.Flags&EDATFlagPlainData == 0 {
+			// the IV is empty before NPD version 2
+			iv := make([]byte, aes.BlockSize)
+			if e.NPD.Version > 1 {
+				copy(iv, e.NPD.Digest[:])
+			}
+
+			c, err := aes.NewCipher(key)
+			if err != nil {
+				return nil, err
+			}
+
+			cipher.NewCBCDecrypter(c, iv).CryptBlocks(data, data)
+		}
+	}
+
+	if block.compressed {
+		out := make([]byte, e.Header.BlockSize)
+		n, err := edatDecompress(out, data)
+		if err != nil {
+			return nil, err
+		}
+
+		return out[:n], nil
+	}
+
+	return data[:block.length], nil
+}
+
+// WriteTo writes the decrypted file to w, checking the hash of every block.
+func (e *EDAT) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+
+	for idx := int64(0); idx < e.numBlocks(); idx++ {
+		data, err := e.readBlock(idx)
+		if err != nil {
+			return written, err
+		}
+
+		if remaining := e.Size() - written; int64(len(data)) > remaining {
+			data = data[:remaining]
+		}
+
+		n, err := w.Write(data)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// DecryptEDAT decrypts an EDAT or SDAT file. The klicensee is ignored by SDAT
+// files.
+func DecryptEDAT(r io.ReaderAt, w io.Writer, klicensee []byte) error {
+	e, err := OpenEDAT(r, klicensee)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.WriteTo(w)
+	return err
+}
+
+func aesCBCDecryptBlock(key, data []byte) ([]byte, error) {
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(c, make([]byte, aes.BlockSize)).CryptBlocks(out, data)
+
+	return out, nil
+}
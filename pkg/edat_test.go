@@ -0,0 +1,285 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+// testKlicensee is the klicensee of the test EDAT files.
+var testKlicensee = []byte("test klicensee!!")
+
+// encryptEDAT builds an EDAT file of data, the test counterpart of
+// OpenEDAT. SDAT files ignore the klicensee.
+func encryptEDAT(t *testing.T, version, flags uint32, blockSize int, klicensee, data []byte) []byte {
+	npd := NPDHeader{Magic: npdMagic, Version: version, License: 3, Type: 1}
+	copy(npd.ContentID[:], testContentID)
+	copy(npd.Digest[:], randomData(16))
+	copy(npd.DevHash[:], randomData(32)[16:])
+
+	header := EDATHeader{Flags: flags, BlockSize: uint32(blockSize), FileSize: uint64(len(data))}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, npd)
+	binary.Write(&buf, binary.BigEndian, header)
+
+	e := &EDAT{NPD: npd, Header: header, key: klicensee}
+	if flags&EDATFlagSDAT != 0 {
+		e.key = make([]byte, aes.BlockSize)
+		xorBytes(e.key, npd.DevHash[:], KeySDAT)
+	}
+
+	numBlocks := int(e.numBlocks())
+	metaSize := int(e.metadataSize())
+
+	file := make([]byte, edatMetadataOffset)
+	copy(file, buf.Bytes())
+
+	if flags&EDATFlagBlockHeaders == 0 {
+		file = append(file, make([]byte, numBlocks*metaSize)...)
+	}
+
+	for idx := 0; idx < numBlocks; idx++ {
+		block := data[idx*blockSize:]
+		if len(block) > blockSize {
+			block = block[:blockSize]
+		}
+
+		compressed := false
+		if flags&EDATFlagCompressed != 0 {
+			if packed := edatCompress(block, 3, true); len(packed) < len(block) {
+				block, compressed = packed, true
+			}
+		}
+
+		length := len(block)
+		enc := make([]byte, align16(int64(length)))
+		copy(enc, block)
+
+		key, hashKey, err := e.blockKeys(int64(idx))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if flags&EDATFlagPlainData == 0 {
+			iv := make([]byte, aes.BlockSize)
+			if version > 1 {
+				copy(iv, npd.Digest[:])
+			}
+
+			c, _ := aes.NewCipher(key)
+			cipher.NewCBCEncrypter(c, iv).CryptBlocks(enc, enc)
+		}
+
+		var hash []byte
+		if flags&EDATFlagHMACHash == 0 {
+			hash, _ = AESCMAC(hashKey, enc)
+		} else {
+			mac := hmac.New(sha1.New, hashKey)
+			mac.Write(enc)
+			hash = mac.Sum(nil)[:0x10]
+		}
+
+		meta := make([]byte, metaSize)
+		copy(meta, hash)
+
+		switch {
+		case flags&EDATFlagCompressed != 0:
+			info := make([]byte, 0x10)
+			binary.BigEndian.PutUint64(info, uint64(len(file)))
+			binary.BigEndian.PutUint32(info[0x8:], uint32(length))
+			if compressed {
+				binary.BigEndian.PutUint32(info[0xc:], 1)
+			}
+
+			copy(meta[0x10:], info)
+			if version > 1 {
+				for i := 0; i < 4; i++ {
+					meta[0x10+i] = info[i] ^ meta[0xc+i] ^ meta[0x8+i]
+					meta[0x14+i] = info[0x4+i] ^ meta[0x4+i] ^ meta[0x8+i]
+					meta[0x18+i] = info[0x8+i] ^ meta[0xc+i] ^ meta[i]
+					meta[0x1c+i] = info[0xc+i] ^ meta[0x4+i] ^ meta[i]
+				}
+			}
+
+			copy(file[edatMetadataOffset+idx*metaSize:], meta)
+		case flags&EDATFlagBlockHeaders != 0:
+			// the hash is split between the two halves
+			copy(meta[0x10:], randomData(0x10))
+			xorBytes(meta[:0x10], hash, meta[0x10:])
+			file = append(file, meta...)
+		default:
+			copy(file[edatMetadataOffset+idx*metaSize:], meta)
+		}
+
+		file = append(file, enc...)
+	}
+
+	return file
+}
+
+func TestEDAT(t *testing.T) {
+	data := testText(0x2345)
+
+	tests := []struct {
+		name    string
+		version uint32
+		flags   uint32
+	}{
+		{"version 1", 1, 0},
+		{"version 2", 2, 0},
+		{"plain data", 2, EDATFlagPlainData},
+		{"HMAC", 3, EDATFlagHMACHash},
+		{"encrypted key", 3, EDATFlagEncryptedKey | EDATFlagHMACHash},
+		{"encrypted key version 4", 4, EDATFlagEncryptedKey | EDATFlagHMACHash},
+		{"block headers", 3, EDATFlagBlockHeaders | EDATFlagHMACHash},
+		{"compressed version 1", 1, EDATFlagCompressed},
+		{"compressed", 4, EDATFlagCompressed | EDATFlagEncryptedKey | EDATFlagHMACHash},
+		{"sdat", 3, EDATFlagSDAT | EDATFlagEncryptedKey | EDATFlagHMACHash | EDATFlagBlockHeaders},
+	}
+
+	for _, tt := range tests {
+		file := encryptEDAT(t, tt.version, tt.flags, 0x1000, testKlicensee, data)
+
+		e, err := OpenEDAT(bytes.NewReader(file), testKlicensee)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if e.GetContentID() != testContentID || e.Size() != int64(len(data)) {
+			t.Errorf("%s: got content ID %q, size %d", tt.name, e.GetContentID(), e.Size())
+		}
+
+		var out bytes.Buffer
+		if _, err := e.WriteTo(&out); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if !bytes.Equal(out.Bytes(), data) {
+			t.Fatalf("%s: the decrypted data doesn't match", tt.name)
+		}
+
+		// a flipped bit of the last block breaks its hash
+		file[len(file)-1] ^= 1
+		if err := DecryptEDAT(bytes.NewReader(file), &out, testKlicensee); err != ErrEDATHash {
+			t.Errorf("%s: tampered block returned %v", tt.name, err)
+		}
+	}
+}
+
+func TestOpenEDATLicense(t *testing.T) {
+	data := testText(100)
+
+	edat := encryptEDAT(t, 3, 0, 0x1000, testKlicensee, data)
+	if _, err := OpenEDAT(bytes.NewReader(edat), nil); err != ErrEDATLicense {
+		t.Fatalf("got %v, want %v", err, ErrEDATLicense)
+	}
+
+	// the wrong klicensee fails the hash of the first block
+	var out bytes.Buffer
+	if err := DecryptEDAT(bytes.NewReader(edat), &out, make([]byte, 16)); err != ErrEDATHash {
+		t.Fatalf("wrong klicensee returned %v", err)
+	}
+
+	// the SDAT files don't need one
+	sdat := encryptEDAT(t, 3, EDATFlagSDAT, 0x1000, nil, data)
+	if err := DecryptEDAT(bytes.NewReader(sdat), &out, nil); err != nil || !bytes.Equal(out.Bytes(), data) {
+		t.Fatalf("SDAT returned %v", err)
+	}
+
+	if _, err := OpenEDAT(bytes.NewReader(edat[:0x40]), testKlicensee); err == nil {
+		t.Fatal("opened a truncated EDAT")
+	}
+
+	if IsEDAT([]byte("SCE\x00")) || !IsEDAT(edat) {
+		t.Fatal("wrong NPD magic check")
+	}
+}
+
+func TestEDATFixtures(t *testing.T) {
+	// testdata/test.edat is compressed, licensed with testRAP. Both files
+	// were checked with a transcription of the RPCS3 decrypter
+	klicensee, err := RAPToKlicensee(testRAP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		klicensee []byte
+		flags     uint32
+	}{
+		{"test.edat", klicensee, EDATFlagCompressed | EDATFlagEncryptedKey | EDATFlagHMACHash},
+		{"test.sdat", nil, EDATFlagSDAT | 0x3c},
+	}
+
+	for _, tt := range tests {
+		data := readTestdata(t, tt.name)
+
+		e, err := OpenEDAT(bytes.NewReader(data), tt.klicensee)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if e.Header.Flags != tt.flags || e.IsSDAT() != (tt.klicensee == nil) {
+			t.Errorf("%s: got flags %#x", tt.name, e.Header.Flags)
+		}
+
+		var out bytes.Buffer
+		if _, err := e.WriteTo(&out); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if !bytes.Equal(out.Bytes(), testText(0x2345)) {
+			t.Fatalf("%s: the decrypted data doesn't match", tt.name)
+		}
+	}
+}
+
+func TestUnpackEDAT(t *testing.T) {
+	fsys := fstest.MapFS{
+		"USRDIR/MOVIE.EDAT": {Data: readTestdata(t, "test.edat")},
+		"USRDIR/DATA.SDAT":  {Data: readTestdata(t, "test.sdat")},
+		// not encrypted, copied as is
+		"USRDIR/PLAIN.EDAT": {Data: []byte("plain")},
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter("UP9000-NPUJ00000_00-0000000000000000", ContentTypeGameExec, 0)
+	if err := w.WriteFS(&buf, fsys); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.DecryptEDAT = true
+	r.EDATKey = testRAPKlicensee
+
+	dir := t.TempDir()
+	if err := r.Unpack(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]byte{
+		"MOVIE.EDAT": testText(0x2345),
+		"DATA.SDAT":  testText(0x2345),
+		"PLAIN.EDAT": []byte("plain"),
+	}
+
+	for name, data := range expected {
+		out, err := ioutil.ReadFile(filepath.Join(dir, "dev_hdd0/game/NPUJ00000/USRDIR", name))
+		if err != nil || !bytes.Equal(out, data) {
+			t.Errorf("%s wasn't decrypted: %v", name, err)
+		}
+	}
+}
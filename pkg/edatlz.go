@@ -0,0 +1,137 @@
+package pkg
+
+import "encoding/binary"
+
+// The compressed EDAT blocks use another LZ77 variant on the range coder of
+// LZRC, with a different layout of the models. The offsets below are the
+// ones of the original decoder, some trees share their probabilities.
+const (
+	edatProbsSize = 0xca8
+	// the literal trees are 0xff bytes apart
+	edatLiteralProbs = -1
+	edatLengthProbs  = 0x7f1
+	// the short matches use their own offset trees
+	edatShortOffsetProbs = edatLengthProbs + 0xf8
+	edatOffsetProbs      = 0x928
+	edatMatchProbs       = 0xb68
+	edatLengthBitsProbs  = 0xba8
+
+	// with a header byte above it the block is stored uncompressed
+	edatStored = 0x80
+	// match length that marks the end of the stream
+	edatEndMarker = 0xff
+)
+
+// edatDecompress decompresses a compressed EDAT block into dst and returns
+// the size of the decompressed data.
+func edatDecompress(dst, src []byte) (int, error) {
+	if len(src) < lzrcHeaderSize {
+		return 0, errDecompressTruncated
+	}
+
+	head := uint(src[0])
+	code := binary.BigEndian.Uint32(src[1:])
+
+	if head > edatStored {
+		if int64(code) > int64(len(dst)) {
+			return 0, errDecompressOverflow
+		}
+
+		if int64(code) > int64(len(src)-lzrcHeaderSize) {
+			return 0, errDecompressTruncated
+		}
+
+		return copy(dst, src[lzrcHeaderSize:lzrcHeaderSize+int(code)]), nil
+	}
+
+	d := newRangeDecoder(src, code, edatProbsSize)
+
+	out := 0
+	state := 0
+	var prev byte
+
+	for d.err == nil {
+		if d.bit(edatMatchProbs+state) == 0 {
+			// literal byte, the stream may end when the output is full
+			if state > 0 {
+				state--
+			}
+
+			if out == len(dst) {
+				return out, nil
+			}
+
+			context := int(((out&7)<<8+int(prev))>>head) & 7
+			dst[out] = byte(d.bitTree(edatLiteralProbs+context*0xff, 0x100))
+			out++
+		} else {
+			// the unary length of the length bits comes first
+			lenBits := -1
+			bitFlag := 1
+			for step := 1; bitFlag != 0 && lenBits < 6; step++ {
+				bitFlag = d.bit(edatMatchProbs + state + step*8)
+				lenBits += bitFlag
+			}
+
+			offsetProbs := edatLengthProbs + lenBits
+			offsetSize := 0x160
+
+			length := 1
+			if lenBits >= 0 || bitFlag != 0 {
+				ctx := lenBits<<5 | ((out<<uint(lenBits))&3)<<3 | state&7
+				length = d.number(edatLengthBitsProbs+ctx, 8, 0x18, lenBits+1)
+				if length == edatEndMarker {
+					break
+				}
+			}
+
+			if length <= 2 {
+				offsetProbs += edatShortOffsetProbs - edatLengthProbs
+				offsetSize = 0x40
+			}
+
+			// the offset bits are coded as a tree until it reaches the size
+			var diff int
+			for n := 1; ; {
+				diff = n<<4 - offsetSize
+				bitFlag = d.bit(offsetProbs + n<<3)
+				n = n<<1 + bitFlag
+
+				if diff >= 0 {
+					break
+				}
+			}
+
+			offset := 1
+			if diff > 0 || bitFlag != 0 {
+				if bitFlag == 0 {
+					diff -= 8
+				}
+
+				// unlike the lengths, the high bits of the offsets skip
+				// a probability
+				offset = d.number(edatOffsetProbs+diff, 1, 4, diff/8+1)
+			}
+
+			if offset > out {
+				return out, errDecompressDistance
+			}
+
+			if out+length+1 > len(dst) {
+				return out, errDecompressOverflow
+			}
+
+			// the copy may overlap
+			for i := 0; i <= length; i++ {
+				dst[out] = dst[out-offset]
+				out++
+			}
+
+			state = 6 + (out+1)&1
+		}
+
+		prev = dst[out-1]
+	}
+
+	return out, d.err
+}
@@ -0,0 +1,151 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/bits"
+	"testing"
+)
+
+// edatCompress is a greedy compressor of the EDAT blocks, the test
+// counterpart of edatDecompress. Without the end marker the stream ends when
+// the output is full.
+func edatCompress(data []byte, head uint, marker bool) []byte {
+	e := newRangeEncoder(edatProbsSize)
+
+	state := 0
+	var prev byte
+
+	for pos := 0; ; {
+		length, dist := findMatch(data, pos, 0xff, 0x1000)
+		// the short matches only code offsets of up to 8 bits
+		if length <= 3 && dist >= 0x100 {
+			length = 0
+		}
+
+		if pos == len(data) && !marker {
+			// a literal past the end of the output
+			e.bit(edatMatchProbs+state, 0)
+			break
+		}
+
+		if pos == len(data) {
+			// end marker, a length with 7 bits
+			e.bit(edatMatchProbs+state, 1)
+			for step := 1; step <= 7; step++ {
+				e.bit(edatMatchProbs+state+step*8, 1)
+			}
+
+			ctx := 6<<5 | ((pos<<6)&3)<<3 | state&7
+			e.number(edatLengthBitsProbs+ctx, 8, 0x18, 7, edatEndMarker)
+			break
+		}
+
+		if length < 2 {
+			e.bit(edatMatchProbs+state, 0)
+			if state > 0 {
+				state--
+			}
+
+			context := int(((pos&7)<<8+int(prev))>>head) & 7
+			e.bitTree(edatLiteralProbs+context*0xff, 0x100|int(data[pos]))
+			pos++
+		} else {
+			e.bit(edatMatchProbs+state, 1)
+
+			length--
+			lenBits := bits.Len(uint(length)) - 2
+			for step := 1; step <= 7; step++ {
+				b := 0
+				if step <= lenBits+1 {
+					b = 1
+				}
+
+				e.bit(edatMatchProbs+state+step*8, b)
+				if b == 0 {
+					break
+				}
+			}
+
+			if lenBits >= 0 {
+				ctx := lenBits<<5 | ((pos<<uint(lenBits))&3)<<3 | state&7
+				e.number(edatLengthBitsProbs+ctx, 8, 0x18, lenBits+1, length)
+			}
+
+			offsetProbs, offsetSize := edatLengthProbs+lenBits, 0x160
+			if length <= 2 {
+				offsetProbs, offsetSize = edatShortOffsetProbs+lenBits, 0x40
+			}
+
+			// the tree of the offset bits, 8 bytes apart
+			offsetBits := bits.Len(uint(dist)) - 2
+			value := offsetBits + offsetSize/8 + 1
+			n := 1
+			for i := bits.Len(uint(value)) - 2; i >= 0; i-- {
+				b := value >> uint(i) & 1
+				e.bit(offsetProbs+n<<3, b)
+				n = n<<1 + b
+			}
+
+			if offsetBits >= 0 {
+				e.number(edatOffsetProbs+offsetBits*8, 1, 4, offsetBits+1, dist)
+			}
+
+			pos += length + 1
+			state = 6 + (pos+1)&1
+		}
+
+		prev = data[pos-1]
+	}
+
+	return e.finish(byte(head))
+}
+
+func TestEDATDecompress(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"text", testText(0x4000)},
+		{"random", randomData(3000)},
+		{"zeros", make([]byte, 0x4000)},
+		{"long offsets", append(testText(0x3000), randomData(0x1000)...)},
+	}
+
+	for _, tt := range tests {
+		// the blocks are decompressed into a buffer of the block size
+		compressed := edatCompress(tt.data, 3, true)
+		out := make([]byte, 0x8000)
+		n, err := edatDecompress(out, compressed)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if !bytes.Equal(out[:n], tt.data) {
+			t.Fatalf("%s: the decompressed data doesn't match", tt.name)
+		}
+
+		compressed = edatCompress(tt.data, 3, false)
+		n, err = edatDecompress(out[:len(tt.data)], compressed)
+		if err != nil || !bytes.Equal(out[:n], tt.data) {
+			t.Fatalf("%s: the data without end marker doesn't match: %v", tt.name, err)
+		}
+	}
+}
+
+func TestEDATDecompressStored(t *testing.T) {
+	data := []byte("stored data")
+	src := make([]byte, lzrcHeaderSize)
+	src[0] = edatStored + 1
+	binary.BigEndian.PutUint32(src[1:], uint32(len(data)))
+
+	out := make([]byte, 100)
+	n, err := edatDecompress(out, append(src, data...))
+	if err != nil || !bytes.Equal(out[:n], data) {
+		t.Fatalf("got %q, %v", out[:n], err)
+	}
+
+	if _, err := edatDecompress(out[:5], append(src, data...)); err != errDecompressOverflow {
+		t.Fatalf("short output returned %v", err)
+	}
+}
@@ -1,31 +1,80 @@
 package pkg
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"encoding/binary"
+	"errors"
 	"io"
+	"io/fs"
+	"path"
+	"time"
 )
 
-type fileEntry struct {
-	Name   string
-	Offset int64
-	Size   int64
-	Flags  uint32
-	Key    cipher.Block
+// An Entry describes a file or directory stored in a pkg archive.
+type Entry struct {
+	name   string
+	offset int64
+	size   int64
+	flags  uint32
+	key    cipher.Block
+	ps3Key bool
+	// position of the encrypted name in the data section
+	nameOffset int64
 }
 
-func (h *fileEntry) FileType() FileTypeEnum {
-	flag := h.Flags & 0xff
+// entryInfo is the fs.FileInfo view of an Entry.
+type entryInfo struct {
+	e *Entry
+}
+
+// Name returns the full path of the entry inside the pkg.
+func (h *Entry) Name() string {
+	return h.name
+}
+
+// Size returns the size of the entry data.
+func (h *Entry) Size() int64 {
+	return h.size
+}
+
+// Offset returns the offset of the entry data relative to the pkg data section.
+func (h *Entry) Offset() int64 {
+	return h.offset
+}
+
+// Flags returns the raw item record flags.
+func (h *Entry) Flags() uint32 {
+	return h.flags
+}
+
+func (h *Entry) FileType() FileTypeEnum {
+	flag := h.flags & 0xff
 	return FileTypeEnum(flag)
 }
 
-func (h *fileEntry) KeyType() uint16 {
-	flag := h.Flags >> 24 & 0xff
+func (h *Entry) KeyType() uint16 {
+	flag := h.flags >> 24 & 0xff
 	return uint16(flag)
 }
 
-func (h *fileEntry) IsFile() bool {
+// IsPSPKeyed reports whether the entry is encrypted with the PSP key.
+func (h *Entry) IsPSPKeyed() bool {
+	return h.KeyType() == EntryTypePSP
+}
+
+// IsPS3Keyed reports whether the entry is encrypted with the PS3 key.
+func (h *Entry) IsPS3Keyed() bool {
+	return h.ps3Key
+}
+
+// Info returns a fs.FileInfo describing the entry.
+func (h *Entry) Info() fs.FileInfo {
+	return entryInfo{h}
+}
+
+func (h *Entry) IsFile() bool {
 	switch h.FileType() {
 	case FileTypeFile0:
 		fallthrough
@@ -62,7 +111,7 @@ func (h *fileEntry) IsFile() bool {
 	}
 }
 
-func (h *fileEntry) IsDirectory() bool {
+func (h *Entry) IsDirectory() bool {
 	switch h.FileType() {
 	case FileTypeDirectory:
 		fallthrough
@@ -73,7 +122,7 @@ func (h *fileEntry) IsDirectory() bool {
 	}
 }
 
-func (pr *Reader) readFileIndex() ([]fileEntry, error) {
+func (pr *Reader) readFileIndex() ([]Entry, error) {
 	itemRecords := make([]ItemRecord, pr.FileHeader.ItemCount)
 	err := binary.Read(pr.reader, binary.BigEndian, &itemRecords)
 	if err != nil {
@@ -82,6 +131,11 @@ func (pr *Reader) readFileIndex() ([]fileEntry, error) {
 
 	recordListSize := binary.Size(itemRecords)
 	tableSize := itemRecords[0].DataOffset - int64(itemRecords[0].FilenameOffset)
+	if tableSize < 0 || int64(recordListSize)+tableSize > pr.FileHeader.DataSize {
+		// usually the result of decrypting with the wrong key
+		return nil, errors.New("invalid PKG file index")
+	}
+
 	tableBuffer := make([]byte, tableSize)
 	// do not use the current aes reader since the names
 	// table could be encrypted using different keys
@@ -93,41 +147,110 @@ func (pr *Reader) readFileIndex() ([]fileEntry, error) {
 	// advance the read stream
 	pr.aesReader.SetCounter((int64(recordListSize) + tableSize) / 16)
 
-	var ps3ctr cipher.Block
-
-	if pr.extendedHeader.KeyType() == 1 {
-		ps3ctr, err = aes.NewCipher(KeyPS3)
-		if err != nil {
-			return nil, err
-		}
-	}
+	// ciphers of the entry key types
+	entryCiphers := map[uint16]*entryKey{}
 
-	entries := make([]fileEntry, pr.FileHeader.ItemCount)
+	entries := make([]Entry, pr.FileHeader.ItemCount)
 
 	for idx, entry := range itemRecords {
 		counter := int64(entry.FilenameOffset / 16)
 		tableOffset := int(entry.FilenameOffset) - recordListSize
+		if tableOffset < 0 || entry.FilenameSize < 0 || tableOffset+int(entry.FilenameSize) > len(tableBuffer) {
+			return nil, errors.New("invalid PKG file index")
+		}
+
 		encryptedName := tableBuffer[tableOffset : tableOffset+int(entry.FilenameSize)]
 
-		var ctr cipher.Block
-		if pr.pkgType == PackageTypePSP || pr.pkgType == PackageTypePSOne {
-			if entry.KeyType() == EntryTypePSP {
-				ctr = pr.aesReader.block
-			} else {
-				ctr = ps3ctr
+		ctr := pr.aesReader.block
+		// debug packages use the same keystream for every entry
+		if (pr.pkgType == PackageTypePSP || pr.pkgType == PackageTypePSOne) && !pr.FileHeader.IsDebug() {
+			ek, err := pr.entryCipher(entryCiphers, entry.KeyType())
+			if err != nil {
+				return nil, err
+			}
+
+			if ek.block != nil {
+				ctr = ek.block
+				entries[idx].ps3Key = ek.ps3
 			}
-		} else {
-			ctr = pr.aesReader.block
 		}
 
 		AESCTRDecrypt(ctr, encryptedName, encryptedName, pr.FileHeader.DataIV[:], counter)
 
-		entries[idx].Name = string(encryptedName)
-		entries[idx].Size = entry.DataSize
-		entries[idx].Offset = entry.DataOffset
-		entries[idx].Flags = entry.Flags
-		entries[idx].Key = ctr
+		entries[idx].name = string(encryptedName)
+		entries[idx].nameOffset = int64(entry.FilenameOffset)
+		entries[idx].size = entry.DataSize
+		entries[idx].offset = entry.DataOffset
+		entries[idx].flags = entry.Flags
+		entries[idx].key = ctr
 	}
 
 	return entries, nil
 }
+
+func (fi entryInfo) Name() string {
+	return path.Base(fi.e.name)
+}
+
+func (fi entryInfo) Size() int64 {
+	if fi.e.IsDirectory() {
+		return 0
+	}
+
+	return fi.e.size
+}
+
+func (fi entryInfo) Mode() fs.FileMode {
+	if fi.e.IsDirectory() {
+		return fs.ModeDir | 0555
+	}
+
+	return 0444
+}
+
+func (fi entryInfo) ModTime() time.Time {
+	return time.Time{}
+}
+
+func (fi entryInfo) IsDir() bool {
+	return fi.e.IsDirectory()
+}
+
+func (fi entryInfo) Sys() interface{} {
+	return fi.e
+}
+
+// An entryKey is the cipher of an entry key type.
+type entryKey struct {
+	// block is nil for the entries using the pkg key
+	block cipher.Block
+	// ps3 is set when the key is the PS3 key
+	ps3 bool
+}
+
+// entryCipher returns the cipher of the entry key type. The ciphers are
+// cached in the given map.
+func (pr *Reader) entryCipher(ciphers map[uint16]*entryKey, keyType uint16) (*entryKey, error) {
+	if ek, ok := ciphers[keyType]; ok {
+		return ek, nil
+	}
+
+	key, err := pr.keyProvider().EntryKey(keyType)
+	if err != nil {
+		return nil, err
+	}
+
+	ek := &entryKey{}
+	if key != nil {
+		ek.block, err = aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+
+		ek.ps3 = bytes.Equal(key, KeyPS3)
+	}
+
+	ciphers[keyType] = ek
+
+	return ek, nil
+}
@@ -1,5 +1,7 @@
 package pkg
 
+import "fmt"
+
 const EntryTypePSP = 0x90
 
 type IdentifierType uint32
@@ -15,19 +17,48 @@ const (
 type ContentTypeEnum uint32
 
 const (
-	ContentTypePS1     ContentTypeEnum = 0x6
-	ContentTypePSP     ContentTypeEnum = 0x7
-	ContentTypePSPGo   ContentTypeEnum = 0xe
-	ContentTypeMinis   ContentTypeEnum = 0xf
-	ContentTypeNeoGeo  ContentTypeEnum = 0x10
-	ContentTypeVitaApp ContentTypeEnum = 0x15
-	ContentTypeVitaDLC ContentTypeEnum = 0x16
-	ContentTypePSM1    ContentTypeEnum = 0x18
-	ContentTypePSM2    ContentTypeEnum = 0x1c
+	ContentTypeGameData  ContentTypeEnum = 0x4
+	ContentTypeGameExec  ContentTypeEnum = 0x5
+	ContentTypePS1       ContentTypeEnum = 0x6
+	ContentTypePSP       ContentTypeEnum = 0x7
+	ContentTypeTheme     ContentTypeEnum = 0x9
+	ContentTypeWidget    ContentTypeEnum = 0xa
+	ContentTypeLicense   ContentTypeEnum = 0xb
+	ContentTypeVSHModule ContentTypeEnum = 0xc
+	ContentTypeAvatar    ContentTypeEnum = 0xd
+	ContentTypePSPGo     ContentTypeEnum = 0xe
+	ContentTypeMinis     ContentTypeEnum = 0xf
+	ContentTypeNeoGeo    ContentTypeEnum = 0x10
+	ContentTypeVMC       ContentTypeEnum = 0x11
+	ContentTypePS2       ContentTypeEnum = 0x12
+	ContentTypeVitaApp   ContentTypeEnum = 0x15
+	ContentTypeVitaDLC   ContentTypeEnum = 0x16
+	ContentTypePSM1      ContentTypeEnum = 0x18
+	ContentTypePSM2      ContentTypeEnum = 0x1c
 )
 
 type FileTypeEnum int
 
+var fileTypeNames = map[FileTypeEnum]string{
+	FileTypeFile0:         "File0",
+	FileTypeFile1:         "File1",
+	FileTypeFileEdat:      "Edat",
+	FileTypeFile3:         "File3",
+	FileTypeDirectory:     "Directory",
+	FileTypeFileDocinfo:   "Docinfo",
+	FileTypeFilePbp:       "Pbp",
+	FileTypeFileModule:    "Module",
+	FileTypeFile15:        "File15",
+	FileTypeFileKeystone:  "Keystone",
+	FileTypeFilePfs:       "Pfs",
+	FileTypeDirectoryPfs:  "DirectoryPfs",
+	FileTypeFileTemp:      "Temp",
+	FileTypeFileInst:      "Inst",
+	FileTypeFileClearsign: "Clearsign",
+	FileTypeFileSys:       "Sys",
+	FileTypeFileDigs:      "Digs",
+}
+
 const (
 	FileTypeFile0         FileTypeEnum = 0
 	FileTypeFile1         FileTypeEnum = 1
@@ -47,3 +78,11 @@ const (
 	FileTypeFileSys       FileTypeEnum = 22
 	FileTypeFileDigs      FileTypeEnum = 24
 )
+
+func (t FileTypeEnum) String() string {
+	if name, ok := fileTypeNames[t]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("FileType(%d)", int(t))
+}
@@ -22,7 +22,7 @@ func (pr *Reader) Read(b []byte) (int, error) {
 	return n, err
 }
 
-func (pr *Reader) Next() (*fileEntry, error) {
+func (pr *Reader) Next() (*Entry, error) {
 	if pr.err != nil {
 		return nil, pr.err
 	}
@@ -52,7 +52,7 @@ func (pr *Reader) skipUnread() error {
 	return err
 }
 
-func (pr *Reader) next() (*fileEntry, error) {
+func (pr *Reader) next() (*Entry, error) {
 	if err := pr.skipUnread(); err != nil {
 		return nil, err
 	}
@@ -69,7 +69,7 @@ func (pr *Reader) next() (*fileEntry, error) {
 	return entry, nil
 }
 
-func (pr *Reader) readNextEntry() (*fileEntry, error) {
+func (pr *Reader) readNextEntry() (*Entry, error) {
 	e := &pr.index
 
 	if e.idx >= len(e.itemRecords) {
@@ -87,8 +87,8 @@ func (pr *Reader) readNextEntry() (*fileEntry, error) {
 	return &entry, nil
 }
 
-func (pr *Reader) handleRegularFile(entry *fileEntry) error {
-	nb := entry.Size
+func (pr *Reader) handleRegularFile(entry *Entry) error {
+	nb := entry.size
 	if entry.IsDirectory() {
 		nb = 0
 	}
@@ -97,19 +97,19 @@ func (pr *Reader) handleRegularFile(entry *fileEntry) error {
 	}
 
 	e := &pr.index
-	curr := e.itemRecords[e.idx-1].Offset
+	curr := e.itemRecords[e.idx-1].offset
 
 	var next int64
 	if e.idx < len(e.itemRecords) {
-		next = e.itemRecords[e.idx].Offset
+		next = e.itemRecords[e.idx].offset
 	} else {
 		next = pr.FileHeader.DataSize
 	}
 
-	r := NewCTR(entry.Key, pr.FileHeader.DataIV[:], entry.Offset/16)
+	r := NewCTR(entry.key, pr.FileHeader.DataIV[:], entry.offset/16)
 	reader := cipher.StreamReader{S: r, R: pr.aesReader.RawReader()}
 
-	pr.pad = next - curr - entry.Size
+	pr.pad = next - curr - entry.size
 	pr.current = &regFileReader{r: reader, nb: nb}
 	return nil
 }
@@ -0,0 +1,249 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// An fsNode is a file or directory of the tree exposed by ReaderAt.Open.
+type fsNode struct {
+	name     string
+	size     int64
+	dir      bool
+	open     func() (*io.SectionReader, error)
+	children []*fsNode
+}
+
+// fsFile is an open regular file of the pkg filesystem.
+type fsFile struct {
+	node *fsNode
+	*io.SectionReader
+}
+
+// fsDir is an open directory of the pkg filesystem.
+type fsDir struct {
+	node   *fsNode
+	offset int
+}
+
+// fsInfo implements both fs.FileInfo and fs.DirEntry for a fsNode.
+type fsInfo struct {
+	node *fsNode
+}
+
+var (
+	_ fs.FS        = (*ReaderAt)(nil)
+	_ fs.ReadDirFS = (*ReaderAt)(nil)
+	_ fs.StatFS    = (*ReaderAt)(nil)
+)
+
+func (ra *ReaderAt) buildTree() {
+	root := &fsNode{name: ".", dir: true}
+	ra.nodes = map[string]*fsNode{".": root}
+
+	for idx := range ra.index.itemRecords {
+		entry := &ra.index.itemRecords[idx]
+		name := cleanEntryName(entry.name)
+		if name == "." {
+			continue
+		}
+
+		node := ra.mkdirAll(name)
+		if !entry.IsDirectory() {
+			node.dir = false
+			node.size = entry.size
+			node.open = func() (*io.SectionReader, error) {
+				return ra.OpenEntry(entry)
+			}
+		}
+	}
+
+	if ra.pkgType == PackageTypeVitaDLC ||
+		ra.pkgType == PackageTypeVitaApp ||
+		ra.pkgType == PackageTypeVitaPatch {
+		headSize := int64(ra.headBuffer.Len())
+		tailOffset := ra.FileHeader.DataOffset + ra.FileHeader.DataSize
+		tailSize := ra.FileHeader.TotalSize - tailOffset
+
+		ra.addSynthetic("sce_sys/package/head.bin", io.NewSectionReader(ra.r, 0, headSize))
+		ra.addSynthetic("sce_sys/package/tail.bin", io.NewSectionReader(ra.r, tailOffset, tailSize))
+		ra.addSynthetic("sce_sys/package/work.bin", io.NewSectionReader(bytes.NewReader(ra.rif), 0, int64(len(ra.rif))))
+	}
+
+	for _, node := range ra.nodes {
+		sort.Slice(node.children, func(i, j int) bool {
+			return node.children[i].name < node.children[j].name
+		})
+	}
+}
+
+// mkdirAll returns the node for name, creating it and its parents as
+// directories if they don't exist yet.
+func (ra *ReaderAt) mkdirAll(name string) *fsNode {
+	if node, ok := ra.nodes[name]; ok {
+		return node
+	}
+
+	parent := ra.mkdirAll(path.Dir(name))
+	node := &fsNode{name: path.Base(name), dir: true}
+	parent.children = append(parent.children, node)
+	ra.nodes[name] = node
+
+	return node
+}
+
+func (ra *ReaderAt) addSynthetic(name string, sr *io.SectionReader) {
+	node := ra.mkdirAll(name)
+	node.dir = false
+	node.size = sr.Size()
+	node.open = func() (*io.SectionReader, error) {
+		return io.NewSectionReader(sr, 0, sr.Size()), nil
+	}
+}
+
+func cleanEntryName(name string) string {
+	return path.Clean(strings.TrimLeft(name, "/"))
+}
+
+func (ra *ReaderAt) lookupNode(op, name string) (*fsNode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	node, ok := ra.nodes[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	return node, nil
+}
+
+// Open opens the named file of the pkg, implementing fs.FS.
+func (ra *ReaderAt) Open(name string) (fs.File, error) {
+	node, err := ra.lookupNode("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if node.dir {
+		return &fsDir{node: node}, nil
+	}
+
+	sr, err := node.open()
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return &fsFile{node: node, SectionReader: sr}, nil
+}
+
+// ReadDir reads the named directory, implementing fs.ReadDirFS.
+func (ra *ReaderAt) ReadDir(name string) ([]fs.DirEntry, error) {
+	node, err := ra.lookupNode("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !node.dir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+
+	entries := make([]fs.DirEntry, len(node.children))
+	for idx, child := range node.children {
+		entries[idx] = fsInfo{child}
+	}
+
+	return entries, nil
+}
+
+// Stat returns a fs.FileInfo describing the named file, implementing fs.StatFS.
+func (ra *ReaderAt) Stat(name string) (fs.FileInfo, error) {
+	node, err := ra.lookupNode("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return fsInfo{node}, nil
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) {
+	return fsInfo{f.node}, nil
+}
+
+func (f *fsFile) Close() error {
+	return nil
+}
+
+func (d *fsDir) Read(b []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.node.name, Err: errors.New("is a directory")}
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) {
+	return fsInfo{d.node}, nil
+}
+
+func (d *fsDir) Close() error {
+	return nil
+}
+
+func (d *fsDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	remaining := len(d.node.children) - d.offset
+	if count > 0 && remaining == 0 {
+		return nil, io.EOF
+	}
+
+	if count > 0 && count < remaining {
+		remaining = count
+	}
+
+	entries := make([]fs.DirEntry, remaining)
+	for idx := range entries {
+		entries[idx] = fsInfo{d.node.children[d.offset+idx]}
+	}
+
+	d.offset += remaining
+
+	return entries, nil
+}
+
+func (fi fsInfo) Name() string {
+	return fi.node.name
+}
+
+func (fi fsInfo) Size() int64 {
+	return fi.node.size
+}
+
+func (fi fsInfo) Mode() fs.FileMode {
+	if fi.node.dir {
+		return fs.ModeDir | 0555
+	}
+
+	return 0444
+}
+
+func (fi fsInfo) ModTime() time.Time {
+	return time.Time{}
+}
+
+func (fi fsInfo) IsDir() bool {
+	return fi.node.dir
+}
+
+func (fi fsInfo) Sys() interface{} {
+	return nil
+}
+
+func (fi fsInfo) Type() fs.FileMode {
+	return fi.Mode().Type()
+}
+
+func (fi fsInfo) Info() (fs.FileInfo, error) {
+	return fi, nil
+}
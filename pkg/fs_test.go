@@ -0,0 +1,83 @@
+package pkg
+
+import (
+	"bytes"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+// testVitaRif returns a NoNpDrm style license of the test package.
+func testVitaRif() []byte {
+	rif := make([]byte, 512)
+	copy(rif[0x10:], testContentID)
+	return rif
+}
+
+func TestReaderAtFS(t *testing.T) {
+	data := readTestdata(t, testVitaPkg)
+	rif := testVitaRif()
+
+	zrif, err := EncodeLicense(rif)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ra, err := OpenReaderAt(bytes.NewReader(data), int64(len(data)), zrif)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = fstest.TestFS(ra,
+		"eboot.bin",
+		"sce_sys/icon0.png",
+		"sce_sys/param.sfo",
+		"sce_sys/package/head.bin",
+		"sce_sys/package/tail.bin",
+		"sce_sys/package/work.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eboot, err := fs.ReadFile(ra, "eboot.bin")
+	if err != nil || !bytes.Equal(eboot, randomData(testEbootSize)) {
+		t.Fatalf("eboot.bin doesn't match: %v", err)
+	}
+
+	// head.bin is the start of the pkg up to the end of the file index,
+	// tail.bin what follows the data section
+	head, err := fs.ReadFile(ra, "sce_sys/package/head.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if int64(len(head)) <= ra.FileHeader.DataOffset || !bytes.HasPrefix(data, head) {
+		t.Fatalf("head.bin doesn't match, %d bytes", len(head))
+	}
+
+	tail, err := fs.ReadFile(ra, "sce_sys/package/tail.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(tail, data[ra.FileHeader.DataOffset+ra.FileHeader.DataSize:]) {
+		t.Fatalf("tail.bin doesn't match, %d bytes", len(tail))
+	}
+
+	work, err := fs.ReadFile(ra, "sce_sys/package/work.bin")
+	if err != nil || !bytes.Equal(work, rif) {
+		t.Fatalf("work.bin doesn't match: %v", err)
+	}
+
+	if _, err := ra.Open("/eboot.bin"); err == nil {
+		t.Fatal("opened an invalid path")
+	}
+
+	if _, err := ra.Stat("missing.bin"); err == nil {
+		t.Fatal("found a missing file")
+	}
+
+	if _, err := ra.ReadDir("eboot.bin"); err == nil {
+		t.Fatal("read a file as a directory")
+	}
+}
@@ -20,6 +20,23 @@ type FileHeader struct {
 	HeaderSha1Hash       [8]byte
 }
 
+const (
+	// pkg revision of retail (finalized) packages
+	revisionRetail = 0x8000
+	// pkg type of the packages made for the PS3
+	fileTypePS3 = 1
+)
+
+// IsDebug reports whether the pkg is a debug (non finalized) package.
+func (h *FileHeader) IsDebug() bool {
+	return h.Revision&revisionRetail == 0
+}
+
+// IsPS3 reports whether the pkg was made for the PS3.
+func (h *FileHeader) IsPS3() bool {
+	return h.Type == fileTypePS3
+}
+
 func (h *FileHeader) GetContentID() string {
 	return string(h.ContentID[:])
 }
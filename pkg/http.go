@@ -0,0 +1,304 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// DefaultBlockSize is the size of the blocks fetched by HTTPReaderAt.
+	DefaultBlockSize = 256 * 1024
+	// DefaultReadAhead is the number of extra blocks requested after a cache miss.
+	DefaultReadAhead = 4
+	// DefaultCacheBlocks is the number of blocks kept in memory by HTTPReaderAt.
+	DefaultCacheBlocks = 64
+)
+
+// ErrRangeNotSupported is returned by NewHTTPReaderAt when the server doesn't
+// accept range requests, so the caller can fall back to streaming the file.
+var ErrRangeNotSupported = errors.New("pkg: server doesn't support range requests")
+
+// An HTTPReaderAt is an io.ReaderAt backed by HTTP range requests. Fetched
+// blocks are cached so the pkg headers and index only get downloaded once.
+type HTTPReaderAt struct {
+	client *http.Client
+	url    string
+	size   int64
+
+	// BlockSize is the granularity of the range requests.
+	BlockSize int64
+	// ReadAhead is the number of blocks fetched after the requested one.
+	ReadAhead int
+	// CacheBlocks is the maximum number of cached blocks.
+	CacheBlocks int
+
+	mu    sync.Mutex
+	cache map[int64][]byte
+	order []int64
+}
+
+// NewHTTPReaderAt checks that the server behind url accepts range requests and
+// returns a reader for it. If client is nil then http.DefaultClient is used.
+// The requests that fail with an HTTP error return it instead of
+// ErrRangeNotSupported, there is nothing to stream then.
+func NewHTTPReaderAt(client *http.Client, url string) (*HTTPReaderAt, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Head(url)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body.Close()
+
+	size := resp.ContentLength
+
+	switch {
+	case resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented ||
+		resp.StatusCode == http.StatusOK && size < 0:
+		// some servers reject HEAD requests or don't send the size, ask for
+		// the first byte instead
+		size, err = probeRange(client, url)
+		if err != nil {
+			return nil, err
+		}
+	case resp.StatusCode != http.StatusOK:
+		return nil, statusError(resp)
+	case !strings.Contains(resp.Header.Get("Accept-Ranges"), "bytes"):
+		return nil, ErrRangeNotSupported
+	}
+
+	return &HTTPReaderAt{
+		client:      client,
+		url:         url,
+		size:        size,
+		BlockSize:   DefaultBlockSize,
+		ReadAhead:   DefaultReadAhead,
+		CacheBlocks: DefaultCacheBlocks,
+		cache:       map[int64][]byte{},
+	}, nil
+}
+
+// probeRange requests the first byte of the file to check that the server
+// supports range requests, returning the size of the file.
+func probeRange(client *http.Client, url string) (int64, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Range", "bytes=0-0")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		// the whole file, the range was ignored
+		return 0, ErrRangeNotSupported
+	case resp.StatusCode != http.StatusPartialContent:
+		return 0, statusError(resp)
+	}
+
+	start, end, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil || start != 0 || end != 0 || size < 0 {
+		return 0, ErrRangeNotSupported
+	}
+
+	return size, nil
+}
+
+// parseContentRange decodes a Content-Range header like "bytes 0-99/1000".
+// The size is -1 if the server doesn't know it.
+func parseContentRange(contentRange string) (start, end, size int64, err error) {
+	invalid := fmt.Errorf("pkg: invalid Content-Range: %q", contentRange)
+
+	if !strings.HasPrefix(contentRange, "bytes ") {
+		return 0, 0, 0, invalid
+	}
+
+	byteRange, total, ok := cut(contentRange[len("bytes "):], "/")
+	if !ok {
+		return 0, 0, 0, invalid
+	}
+
+	first, last, ok := cut(byteRange, "-")
+	if !ok {
+		return 0, 0, 0, invalid
+	}
+
+	if start, err = strconv.ParseInt(first, 10, 64); err != nil || start < 0 {
+		return 0, 0, 0, invalid
+	}
+
+	if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+		return 0, 0, 0, invalid
+	}
+
+	size = -1
+	if total != "*" {
+		if size, err = strconv.ParseInt(total, 10, 64); err != nil || size <= end {
+			return 0, 0, 0, invalid
+		}
+	}
+
+	return start, end, size, nil
+}
+
+// cut slices s around the first instance of sep.
+func cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+
+	return s, "", false
+}
+
+// statusError is the error of the requests that failed with an HTTP status.
+func statusError(resp *http.Response) error {
+	return fmt.Errorf("pkg: unexpected HTTP status: %s", resp.Status)
+}
+
+// Size returns the length of the remote file.
+func (hr *HTTPReaderAt) Size() int64 {
+	return hr.size
+}
+
+func (hr *HTTPReaderAt) ReadAt(b []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("pkg: negative offset")
+	}
+
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+
+	for n < len(b) {
+		pos := off + int64(n)
+		if pos >= hr.size {
+			return n, io.EOF
+		}
+
+		idx := pos / hr.BlockSize
+		block, ok := hr.cache[idx]
+		if !ok {
+			block, err = hr.fetch(idx)
+			if err != nil {
+				return n, err
+			}
+		}
+
+		n += copy(b[n:], block[pos-idx*hr.BlockSize:])
+	}
+
+	return n, nil
+}
+
+// fetch downloads the block idx plus the read ahead blocks that aren't cached.
+func (hr *HTTPReaderAt) fetch(idx int64) ([]byte, error) {
+	last := idx
+	for i := 0; i < hr.ReadAhead; i++ {
+		if _, ok := hr.cache[last+1]; ok || (last+1)*hr.BlockSize >= hr.size {
+			break
+		}
+		last++
+	}
+
+	start := idx * hr.BlockSize
+	end := (last+1)*hr.BlockSize - 1
+	if end >= hr.size {
+		end = hr.size - 1
+	}
+
+	req, err := http.NewRequest(http.MethodGet, hr.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Range", "bytes="+strconv.FormatInt(start, 10)+"-"+strconv.FormatInt(end, 10))
+
+	resp, err := hr.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, statusError(resp)
+	}
+
+	// a proxy can ignore or rewrite the range, the body would be other bytes
+	gotStart, gotEnd, gotSize, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return nil, err
+	}
+
+	if gotStart != start || gotEnd != end || gotSize >= 0 && gotSize != hr.size {
+		return nil, fmt.Errorf("pkg: got range %d-%d/%d, requested %d-%d/%d", gotStart, gotEnd, gotSize, start, end, hr.size)
+	}
+
+	data := make([]byte, end-start+1)
+	if _, err = io.ReadFull(resp.Body, data); err != nil {
+		return nil, err
+	}
+
+	var block []byte
+
+	for i := idx; i <= last; i++ {
+		blockStart := (i - idx) * hr.BlockSize
+		blockEnd := blockStart + hr.BlockSize
+		if blockEnd > int64(len(data)) {
+			blockEnd = int64(len(data))
+		}
+
+		if i == idx {
+			block = data[blockStart:blockEnd]
+		}
+
+		hr.store(i, data[blockStart:blockEnd])
+	}
+
+	return block, nil
+}
+
+func (hr *HTTPReaderAt) store(idx int64, block []byte) {
+	if hr.CacheBlocks > 0 && len(hr.order) >= hr.CacheBlocks {
+		delete(hr.cache, hr.order[0])
+		hr.order = hr.order[1:]
+	}
+
+	hr.cache[idx] = block
+	hr.order = append(hr.order, idx)
+}
+
+// OpenURL opens a remote pkg for random access using HTTP range requests.
+func OpenURL(client *http.Client, url string, rif string) (*ReaderAt, error) {
+	hr, err := NewHTTPReaderAt(client, url)
+	if err != nil {
+		return nil, err
+	}
+
+	return OpenReaderAt(hr, hr.Size(), rif)
+}
+
+// OpenURLWithOptions is like OpenURL, see NewReaderWithOptions for the
+// options.
+func OpenURLWithOptions(client *http.Client, url string, opts *ReaderOptions) (*ReaderAt, error) {
+	hr, err := NewHTTPReaderAt(client, url)
+	if err != nil {
+		return nil, err
+	}
+
+	return OpenReaderAtWithOptions(hr, hr.Size(), opts)
+}
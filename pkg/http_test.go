@@ -0,0 +1,282 @@
+package pkg
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func serveBytes(data []byte) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.pkg", time.Time{}, bytes.NewReader(data))
+	})
+}
+
+func TestHTTPReaderAt(t *testing.T) {
+	data := randomData(10000)
+	ts := httptest.NewServer(serveBytes(data))
+	defer ts.Close()
+
+	hr, err := NewHTTPReaderAt(nil, ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hr.Size() != int64(len(data)) {
+		t.Fatalf("size %d, want %d", hr.Size(), len(data))
+	}
+
+	hr.BlockSize = 1000
+	hr.ReadAhead = 1
+	hr.CacheBlocks = 2
+
+	tests := []struct {
+		off, size int
+	}{
+		{0, 10},
+		{990, 20},    // crosses a block
+		{2500, 3000}, // crosses several blocks and the read ahead
+		{0, 10000},
+		{9990, 10}, // tail of the file
+		{500, 10},  // evicted from the cache
+	}
+
+	for _, tt := range tests {
+		b := make([]byte, tt.size)
+		n, err := hr.ReadAt(b, int64(tt.off))
+		if err != nil || n != tt.size {
+			t.Fatalf("ReadAt(%d, %d) = %d, %v", tt.off, tt.size, n, err)
+		}
+
+		if !bytes.Equal(b, data[tt.off:tt.off+tt.size]) {
+			t.Fatalf("ReadAt(%d, %d) returned the wrong data", tt.off, tt.size)
+		}
+	}
+
+	b := make([]byte, 20)
+	n, err := hr.ReadAt(b, 9990)
+	if n != 10 || err != io.EOF {
+		t.Fatalf("ReadAt past the end = %d, %v, want 10, EOF", n, err)
+	}
+}
+
+func TestHTTPReaderAtWithoutHead(t *testing.T) {
+	data := randomData(5000)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		serveBytes(data).ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	hr, err := NewHTTPReaderAt(nil, ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hr.Size() != int64(len(data)) {
+		t.Fatalf("size %d, want %d", hr.Size(), len(data))
+	}
+
+	b := make([]byte, 100)
+	if _, err := hr.ReadAt(b, 4900); err != nil || !bytes.Equal(b, data[4900:]) {
+		t.Fatalf("ReadAt failed: %v", err)
+	}
+}
+
+func TestHTTPReaderAtWithoutRanges(t *testing.T) {
+	data := randomData(5000)
+
+	handlers := map[string]http.HandlerFunc{
+		"full content": func(w http.ResponseWriter, r *http.Request) {
+			w.Write(data)
+		},
+		"no HEAD": func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+
+			w.Write(data)
+		},
+	}
+
+	for name, handler := range handlers {
+		ts := httptest.NewServer(handler)
+
+		if _, err := NewHTTPReaderAt(nil, ts.URL); err != ErrRangeNotSupported {
+			t.Errorf("%s: got %v, want ErrRangeNotSupported", name, err)
+		}
+
+		ts.Close()
+	}
+}
+
+func TestHTTPReaderAtErrors(t *testing.T) {
+	data := randomData(5000)
+
+	handlers := map[string]http.HandlerFunc{
+		"not found": func(w http.ResponseWriter, r *http.Request) {
+			http.NotFound(w, r)
+		},
+		"forbidden GET": func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+
+			w.WriteHeader(http.StatusForbidden)
+		},
+		"server error": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		},
+		"HEAD server error": func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			serveBytes(data).ServeHTTP(w, r)
+		},
+	}
+
+	for name, handler := range handlers {
+		ts := httptest.NewServer(handler)
+
+		_, err := NewHTTPReaderAt(nil, ts.URL)
+		if err == nil || err == ErrRangeNotSupported {
+			t.Errorf("%s: got %v, want an HTTP error", name, err)
+		}
+
+		ts.Close()
+	}
+}
+
+func TestHTTPReaderAtWithoutSize(t *testing.T) {
+	data := randomData(5000)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			// no Content-Length
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		serveBytes(data).ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	hr, err := NewHTTPReaderAt(nil, ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hr.Size() != int64(len(data)) {
+		t.Fatalf("size %d, want %d", hr.Size(), len(data))
+	}
+}
+
+func TestHTTPReaderAtWrongRange(t *testing.T) {
+	data := randomData(5000)
+
+	handlers := map[string]http.HandlerFunc{
+		// a proxy serving the start of the file for every range
+		"rewritten range": func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet && r.Header.Get("Range") != "bytes=0-0" {
+				r.Header.Set("Range", "bytes=0-99")
+			}
+
+			serveBytes(data).ServeHTTP(w, r)
+		},
+		"no Content-Range": func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet {
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(data[:100])
+				return
+			}
+
+			serveBytes(data).ServeHTTP(w, r)
+		},
+	}
+
+	for name, handler := range handlers {
+		ts := httptest.NewServer(handler)
+
+		hr, err := NewHTTPReaderAt(nil, ts.URL)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		b := make([]byte, 100)
+		if _, err := hr.ReadAt(b, 4900); err == nil {
+			t.Errorf("%s: ReadAt succeeded", name)
+		}
+
+		ts.Close()
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header           string
+		start, end, size int64
+		valid            bool
+	}{
+		{"bytes 0-0/5000", 0, 0, 5000, true},
+		{"bytes 100-199/5000", 100, 199, 5000, true},
+		{"bytes 100-199/*", 100, 199, -1, true},
+		{"", 0, 0, 0, false},
+		{"bytes */5000", 0, 0, 0, false},
+		{"bytes 100-99/5000", 0, 0, 0, false},
+		{"bytes 0-5000/5000", 0, 0, 0, false},
+		{"items 0-0/5000", 0, 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		start, end, size, err := parseContentRange(tt.header)
+		if (err == nil) != tt.valid {
+			t.Errorf("%q: got error %v", tt.header, err)
+			continue
+		}
+
+		if tt.valid && (start != tt.start || end != tt.end || size != tt.size) {
+			t.Errorf("%q: got %d-%d/%d", tt.header, start, end, size)
+		}
+	}
+}
+
+func TestOpenURL(t *testing.T) {
+	ts := httptest.NewServer(serveBytes(readTestdata(t, testVitaPkg)))
+	defer ts.Close()
+
+	ra, err := OpenURL(nil, ts.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, ok := ra.Lookup("eboot.bin")
+	if !ok {
+		t.Fatal("eboot.bin not found")
+	}
+
+	r, err := ra.OpenEntry(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, randomData(testEbootSize)) {
+		t.Fatal("eboot.bin doesn't match")
+	}
+}
@@ -0,0 +1,267 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// KeyTypePS3 is the key type used for PS3 packages, which have no key type
+// in the extended header. It is outside of the range of the header key types
+// (0 to 7) so it can't be mistaken for one of them.
+const KeyTypePS3 = -1
+
+// A KeyProvider returns the keys used to decrypt the packages.
+type KeyProvider interface {
+	// PackageKey returns the AES-CTR key of the data section of a package
+	// with the given key type (see KeyTypePS3) and data IV.
+	PackageKey(keyType int, iv []byte) ([]byte, error)
+	// EntryKey returns the key of the PSP and PSOne package entries with the
+	// given entry key type, or nil if they use the package key.
+	EntryKey(keyType uint16) ([]byte, error)
+}
+
+// A KeySet is a KeyProvider backed by a table of keys.
+type KeySet struct {
+	// Keys are used as is by the packages of the key type
+	Keys map[int][]byte
+	// BaseKeys encrypt the data IV of the package to get its key
+	BaseKeys map[int][]byte
+	// EntryKeys by entry key type, EntryTypePSP always uses the package key
+	EntryKeys map[uint16][]byte
+	// DefaultEntryKey is used by the entry key types missing from EntryKeys
+	DefaultEntryKey []byte
+}
+
+// DefaultKeys is the KeyProvider used when none is given.
+var DefaultKeys KeyProvider = NewDefaultKeySet()
+
+// NewDefaultKeySet returns a KeySet with the known retail keys.
+func NewDefaultKeySet() *KeySet {
+	return &KeySet{
+		Keys: map[int][]byte{
+			KeyTypePS3: KeyPS3,
+			1:          KeyPSP,
+		},
+		BaseKeys: map[int][]byte{
+			2: VitaKey2,
+			3: KeyVita3,
+			4: KeyVita4,
+		},
+		EntryKeys:       map[uint16][]byte{},
+		DefaultEntryKey: KeyPS3,
+	}
+}
+
+func (ks *KeySet) PackageKey(keyType int, iv []byte) ([]byte, error) {
+	if key, ok := ks.Keys[keyType]; ok {
+		return key, nil
+	}
+
+	baseKey, ok := ks.BaseKeys[keyType]
+	if !ok {
+		return nil, fmt.Errorf("unknown key type: %v", keyType)
+	}
+
+	// encrypt the iv
+	ctrKey := make([]byte, 16)
+	err := AESECBEncrypt(ctrKey, iv, baseKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return ctrKey, nil
+}
+
+func (ks *KeySet) EntryKey(keyType uint16) ([]byte, error) {
+	if keyType == EntryTypePSP {
+		return nil, nil
+	}
+
+	if key, ok := ks.EntryKeys[keyType]; ok {
+		return key, nil
+	}
+
+	if ks.DefaultEntryKey == nil {
+		return nil, fmt.Errorf("unknown entry key type: %#x", keyType)
+	}
+
+	return ks.DefaultEntryKey, nil
+}
+
+// keySetFile is the JSON representation of a KeySet, with the keys in hex.
+type keySetFile struct {
+	Keys            map[string]string `json:"keys"`
+	BaseKeys        map[string]string `json:"base_keys"`
+	EntryKeys       map[string]string `json:"entry_keys"`
+	DefaultEntryKey string            `json:"default_entry_key"`
+}
+
+// LoadKeyFile reads a key file on top of the default keys, so the file only
+// needs the new or replaced keys. See KeySet.Parse for the format.
+func LoadKeyFile(name string) (*KeySet, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	ks := NewDefaultKeySet()
+	if err := ks.Parse(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	return ks, nil
+}
+
+// Parse reads a key file into the set. The file is either a JSON object:
+//
+//	{"keys": {"1": "07f2c6..."}, "base_keys": {"2": "e31a70..."},
+//	 "entry_keys": {"3": "2e7b71..."}, "default_entry_key": "2e7b71..."}
+//
+// or a text file with one key per line. The key types are in decimal, or in
+// hex with the 0x prefix. The key of the PS3 packages uses the ps3 type:
+//
+//	# comment
+//	key 1 07f2c6...
+//	key ps3 2e7b71...
+//	base_key 2 e31a70...
+//	entry_key 3 2e7b71...
+//	default_entry_key 2e7b71...
+func (ks *KeySet) Parse(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return ks.parseJSON(data)
+	}
+
+	return ks.parseText(data)
+}
+
+func (ks *KeySet) parseJSON(data []byte) error {
+	var file keySetFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+
+	// in a fixed order, so the same file always fails with the same error
+	sections := []struct {
+		kind string
+		keys map[string]string
+	}{
+		{"key", file.Keys},
+		{"base_key", file.BaseKeys},
+		{"entry_key", file.EntryKeys},
+	}
+
+	for _, section := range sections {
+		keyTypes := make([]string, 0, len(section.keys))
+		for keyType := range section.keys {
+			keyTypes = append(keyTypes, keyType)
+		}
+
+		sort.Strings(keyTypes)
+
+		for _, keyType := range keyTypes {
+			if err := ks.setKey(section.kind, keyType, section.keys[keyType]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if file.DefaultEntryKey != "" {
+		return ks.setKey("default_entry_key", "", file.DefaultEntryKey)
+	}
+
+	return nil
+}
+
+func (ks *KeySet) parseText(data []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		var err error
+
+		switch {
+		case len(fields) == 2 && fields[0] == "default_entry_key":
+			err = ks.setKey(fields[0], "", fields[1])
+		case len(fields) == 3:
+			err = ks.setKey(fields[0], fields[1], fields[2])
+		default:
+			err = fmt.Errorf("invalid key definition")
+		}
+
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (ks *KeySet) setKey(kind, keyType, value string) error {
+	key, err := hex.DecodeString(value)
+	if err != nil {
+		return err
+	}
+
+	if len(key) != 16 {
+		return fmt.Errorf("invalid key length: %s", value)
+	}
+
+	if kind == "default_entry_key" {
+		ks.DefaultEntryKey = key
+		return nil
+	}
+
+	n := KeyTypePS3
+	if keyType != "ps3" || kind != "key" {
+		u, err := strconv.ParseUint(keyType, 0, 16)
+		if err != nil {
+			return fmt.Errorf("invalid key type: %s", keyType)
+		}
+
+		n = int(u)
+	}
+
+	if ks.Keys == nil {
+		ks.Keys = map[int][]byte{}
+	}
+
+	if ks.BaseKeys == nil {
+		ks.BaseKeys = map[int][]byte{}
+	}
+
+	if ks.EntryKeys == nil {
+		ks.EntryKeys = map[uint16][]byte{}
+	}
+
+	switch kind {
+	case "key":
+		ks.Keys[n] = key
+		delete(ks.BaseKeys, n)
+	case "base_key":
+		ks.BaseKeys[n] = key
+		delete(ks.Keys, n)
+	case "entry_key":
+		ks.EntryKeys[uint16(n)] = key
+	default:
+		return fmt.Errorf("unknown key kind: %s", kind)
+	}
+
+	return nil
+}
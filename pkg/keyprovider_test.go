@@ -0,0 +1,174 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/sha1"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestKeySetParse(t *testing.T) {
+	ks := NewDefaultKeySet()
+	err := ks.Parse(strings.NewReader(`
+# comment
+key 0 00000000000000000000000000000000
+key ps3 11111111111111111111111111111111
+base_key 0x3 33333333333333333333333333333333
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		keyType int
+		key     byte
+	}{
+		{0, 0x00},
+		{KeyTypePS3, 0x11},
+		{1, KeyPSP[0]},
+	}
+
+	for _, tt := range tests {
+		key, err := ks.PackageKey(tt.keyType, make([]byte, 16))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key[0] != tt.key {
+			t.Errorf("key type %d: got %x", tt.keyType, key)
+		}
+	}
+
+	if !bytes.Equal(ks.BaseKeys[3], bytes.Repeat([]byte{0x33}, 16)) {
+		t.Errorf("base key 3: got %x", ks.BaseKeys[3])
+	}
+
+	if err := ks.Parse(strings.NewReader("base_key ps3 00000000000000000000000000000000")); err == nil {
+		t.Error("the PS3 key type is only valid for the keys")
+	}
+}
+
+func TestKeySetParseJSON(t *testing.T) {
+	ks := NewDefaultKeySet()
+	err := ks.Parse(strings.NewReader(`{
+		"keys": {"0": "00000000000000000000000000000000"},
+		"base_keys": {"0x3": "33333333333333333333333333333333"},
+		"entry_keys": {"0x80": "80808080808080808080808080808080"},
+		"default_entry_key": "44444444444444444444444444444444"
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(ks.BaseKeys[3], bytes.Repeat([]byte{0x33}, 16)) {
+		t.Errorf("base key 3: got %x", ks.BaseKeys[3])
+	}
+
+	if key, _ := ks.EntryKey(0x80); !bytes.Equal(key, bytes.Repeat([]byte{0x80}, 16)) {
+		t.Errorf("entry key 0x80: got %x", key)
+	}
+
+	if key, _ := ks.EntryKey(0x81); !bytes.Equal(key, bytes.Repeat([]byte{0x44}, 16)) {
+		t.Errorf("default entry key: got %x", key)
+	}
+
+	// the first invalid key is always the same one
+	invalid := `{"keys": {"1": "11"}, "base_keys": {"2": "22", "1": "11"}, "entry_keys": {"3": "33"}}`
+	for i := 0; i < 10; i++ {
+		err := NewDefaultKeySet().Parse(strings.NewReader(invalid))
+		if err == nil || err.Error() != "invalid key length: 11" {
+			t.Fatalf("got %v", err)
+		}
+	}
+}
+
+// withEntryKeyType replaces the key type of the entries of a package made by
+// Writer. The entries are still encrypted with the package key.
+func withEntryKeyType(t *testing.T, data []byte, keyType byte) []byte {
+	ra := openTestPackage(t, data)
+	iv := ra.FileHeader.DataIV[:]
+
+	key, err := DefaultKeys.PackageKey(ra.extendedHeader.KeyType(), iv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data = append([]byte(nil), data...)
+	index := data[ra.FileHeader.DataOffset : ra.FileHeader.DataOffset+int64(ra.FileHeader.ItemCount)*32]
+
+	NewCTR(block, iv, 0).XORKeyStream(index, index)
+	for pos := 0; pos < len(index); pos += 32 {
+		index[pos+24] = keyType
+	}
+
+	NewCTR(block, iv, 0).XORKeyStream(index, index)
+
+	tailOffset := len(data) - fileHashSize
+	sum := sha1.Sum(data[:tailOffset])
+	copy(data[tailOffset:], sum[:])
+
+	return data
+}
+
+func TestEntryKeys(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter("UP9000-NPUH00000_00-0000000000000000", ContentTypePSP, 1)
+	if err := w.WriteFS(&buf, fstest.MapFS{"USRDIR/CONTENT/DOCUMENT.DAT": {Data: []byte("document")}}); err != nil {
+		t.Fatal(err)
+	}
+
+	data := withEntryKeyType(t, buf.Bytes(), 0x80)
+
+	tests := []struct {
+		name  string
+		key   []byte
+		ps3   bool
+		valid bool
+	}{
+		// the entries are encrypted with the package key
+		{"package key", KeyPSP, false, true},
+		{"PS3 key", KeyPS3, true, false},
+	}
+
+	for _, tt := range tests {
+		keys := NewDefaultKeySet()
+		keys.EntryKeys[0x80] = tt.key
+
+		r, err := NewReaderWithOptions(bytes.NewReader(data), &ReaderOptions{Keys: keys})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		for _, entry := range r.Entries() {
+			if entry.KeyType() != 0x80 || entry.IsPS3Keyed() != tt.ps3 {
+				t.Errorf("%s: %s has key type %#x, PS3 key %t", tt.name, entry.name, entry.KeyType(), entry.IsPS3Keyed())
+			}
+		}
+
+		if tt.valid {
+			// skip the directories
+			for {
+				entry, err := r.Next()
+				if err != nil {
+					t.Fatalf("%s: %v", tt.name, err)
+				}
+
+				if entry.IsFile() {
+					break
+				}
+			}
+
+			if contents, err := ioutil.ReadAll(r); err != nil || string(contents) != "document" {
+				t.Errorf("%s: got %q, %v", tt.name, contents, err)
+			}
+		}
+	}
+}
@@ -24,3 +24,90 @@ var KeyVita4 = []byte{
 	0xaf, 0x07, 0xfd, 0x59, 0x65, 0x25, 0x27, 0xba,
 	0xf1, 0x33, 0x89, 0x66, 0x8b, 0x17, 0xd9, 0xea,
 }
+
+var KeySDAT = []byte{
+	0x0d, 0x65, 0x5e, 0xf8, 0xe6, 0x74, 0xa9, 0x8a,
+	0xb8, 0x50, 0x5c, 0xfa, 0x7d, 0x01, 0x29, 0x33,
+}
+
+var KeyEDAT0 = []byte{
+	0xbe, 0x95, 0x9c, 0xa8, 0x30, 0x8d, 0xef, 0xa2,
+	0xe5, 0xe1, 0x80, 0xc6, 0x37, 0x12, 0xa9, 0xae,
+}
+
+var KeyEDAT1 = []byte{
+	0x4c, 0xa9, 0xc1, 0x4b, 0x01, 0xc9, 0x53, 0x09,
+	0x96, 0x9b, 0xec, 0x68, 0xaa, 0x0b, 0xc0, 0x81,
+}
+
+var KeyEDATHash0 = []byte{
+	0xef, 0xfe, 0x5b, 0xd1, 0x65, 0x2e, 0xeb, 0xc1,
+	0x19, 0x18, 0xcf, 0x7c, 0x04, 0xd4, 0xf0, 0x11,
+}
+
+var KeyEDATHash1 = []byte{
+	0x3d, 0x92, 0x69, 0x9b, 0x70, 0x5b, 0x07, 0x38,
+	0x54, 0xd8, 0xfc, 0xc6, 0xc7, 0x67, 0x27, 0x47,
+}
+
+var KeyRAP = []byte{
+	0x86, 0x9f, 0x77, 0x45, 0xc1, 0x3f, 0xd8, 0x90,
+	0xcc, 0xf2, 0x91, 0x88, 0xe3, 0xcc, 0x3e, 0xdf,
+}
+
+var KeyRIF = []byte{
+	0xda, 0x7d, 0x4b, 0x5e, 0x49, 0x9a, 0x4f, 0x53,
+	0xb1, 0xc1, 0xa1, 0x4a, 0x74, 0x84, 0x44, 0x3b,
+}
+
+var KeyActDat = []byte{
+	0x5e, 0x06, 0xe0, 0x4f, 0xd9, 0x4a, 0x71, 0xbf,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+}
+
+var rapPbox = []byte{
+	0x0c, 0x03, 0x06, 0x04, 0x01, 0x0b, 0x0f, 0x08,
+	0x02, 0x07, 0x00, 0x05, 0x0a, 0x0e, 0x0d, 0x09,
+}
+
+var rapE1 = []byte{
+	0xa9, 0x3e, 0x1f, 0xd6, 0x7c, 0x55, 0xa3, 0x29,
+	0xb7, 0x5f, 0xdd, 0xa6, 0x2a, 0x95, 0xc7, 0xa5,
+}
+
+var rapE2 = []byte{
+	0x67, 0xd4, 0x5d, 0xa3, 0x29, 0x6d, 0x00, 0x6a,
+	0x4e, 0x7c, 0x53, 0x7b, 0xf5, 0x53, 0x8c, 0x74,
+}
+
+// KIRK engine keys used by the AMCTRL functions of the PSP, by key seed
+var kirkKey38 = []byte{
+	0x12, 0x46, 0x8d, 0x7e, 0x1c, 0x42, 0x20, 0x9b,
+	0xba, 0x54, 0x26, 0x83, 0x5e, 0xb0, 0x33, 0x03,
+}
+
+var kirkKey39 = []byte{
+	0xc4, 0x3b, 0xb6, 0xd6, 0x53, 0xee, 0x67, 0x49,
+	0x3e, 0xa9, 0x5f, 0xbc, 0x0c, 0xed, 0x6f, 0x8a,
+}
+
+var kirkKey63 = []byte{
+	0x9c, 0x9b, 0x13, 0x72, 0xf8, 0xc6, 0x40, 0xcf,
+	0x1c, 0x62, 0xf5, 0xd5, 0x92, 0x7f, 0xfb, 0x44,
+}
+
+// AMCTRL masks of the version key and of the cipher key
+var amctrlMacMask = []byte{
+	0xe3, 0x50, 0xed, 0x1d, 0x91, 0x0a, 0x1f, 0xd0,
+	0x29, 0xbb, 0x1c, 0x3e, 0xf3, 0x40, 0x77, 0xfb,
+}
+
+var amctrlSeedMask = []byte{
+	0x13, 0x5f, 0xa4, 0x7c, 0xab, 0x39, 0x5b, 0xa4,
+	0x76, 0xb8, 0xcc, 0xa9, 0x8f, 0x3a, 0x04, 0x45,
+}
+
+var amctrlKeyMask = []byte{
+	0x67, 0x8d, 0x7f, 0xa3, 0x2a, 0x9c, 0xa0, 0xd1,
+	0x4e, 0x43, 0x1e, 0xbf, 0x04, 0xb0, 0x11, 0xeb,
+}
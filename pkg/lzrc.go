@@ -0,0 +1,235 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// LZRC is the compression of the PSP NPDRM images, an LZ77 variant coded
+// with an adaptive binary range coder. The models are stored in a single
+// table, like the original decoder, so the trees that run past the end of
+// their rows use the same probabilities.
+const (
+	lzrcLiteralProbs  = 0
+	lzrcDistBitsProbs = lzrcLiteralProbs + 8*256
+	lzrcDistProbs     = lzrcDistBitsProbs + 8*39
+	lzrcMatchProbs    = lzrcDistProbs + 18*8
+	lzrcLenProbs      = lzrcMatchProbs + 8*8
+	lzrcProbsSize     = lzrcLenProbs + 8*31
+
+	// the first byte of the stream holds the literal context bits, with the
+	// high bit set the data is stored uncompressed
+	lzrcHeaderSize = 5
+	lzrcStored     = 0x80
+	// match length that marks the end of the stream
+	lzrcEndMarker = 0xff
+)
+
+var (
+	errDecompressTruncated = errors.New("pkg: truncated compressed data")
+	errDecompressOverflow  = errors.New("pkg: compressed data larger than the output")
+	errDecompressDistance  = errors.New("pkg: invalid match distance")
+)
+
+// A rangeDecoder is the adaptive binary range decoder shared by LZRC and the
+// EDAT compression, the probabilities are bytes that start at 0x80.
+type rangeDecoder struct {
+	src   []byte
+	pos   int
+	rng   uint32
+	code  uint32
+	probs []byte
+	err   error
+}
+
+// newRangeDecoder starts decoding src, the code is read from the stream
+// header.
+func newRangeDecoder(src []byte, code uint32, probs int) *rangeDecoder {
+	d := &rangeDecoder{src: src, pos: lzrcHeaderSize, rng: 0xffffffff, code: code}
+	d.probs = make([]byte, probs)
+	for i := range d.probs {
+		d.probs[i] = 0x80
+	}
+
+	return d
+}
+
+func (d *rangeDecoder) readByte() uint32 {
+	if d.pos >= len(d.src) {
+		d.err = errDecompressTruncated
+		return 0
+	}
+
+	d.pos++
+	return uint32(d.src[d.pos-1])
+}
+
+func (d *rangeDecoder) normalize() {
+	if d.rng < 1<<24 {
+		d.rng <<= 8
+		d.code = d.code<<8 | d.readByte()
+	}
+}
+
+func (d *rangeDecoder) bit(prob int) int {
+	d.normalize()
+
+	p := &d.probs[prob]
+	bound := (d.rng >> 8) * uint32(*p)
+	*p -= *p >> 3
+
+	if d.code < bound {
+		d.rng = bound
+		*p += 31
+		return 1
+	}
+
+	d.code -= bound
+	d.rng -= bound
+	return 0
+}
+
+// bitTree decodes bits until the number reaches the limit.
+func (d *rangeDecoder) bitTree(probs, limit int) int {
+	n := 1
+	for n < limit {
+		n = n<<1 + d.bit(probs+n)
+	}
+
+	return n
+}
+
+// number decodes a number of bits below a leading 1, the middle bits of the
+// long numbers are coded without a model. The stride separates the
+// probabilities of the three lowest bits, the two highest bits share the
+// probability at upper.
+func (d *rangeDecoder) number(probs, stride, upper, bits int) int {
+	n := 1
+
+	if bits > 3 {
+		n = n<<1 + d.bit(probs+upper)
+		if bits > 4 {
+			n = n<<1 + d.bit(probs+upper)
+			if bits > 5 {
+				d.normalize()
+				for i := 0; i < bits-5; i++ {
+					d.rng >>= 1
+					n <<= 1
+					if d.code < d.rng {
+						n++
+					} else {
+						d.code -= d.rng
+					}
+				}
+			}
+		}
+	}
+
+	if bits > 0 {
+		n = n<<1 + d.bit(probs)
+		if bits > 1 {
+			n = n<<1 + d.bit(probs+stride)
+			if bits > 2 {
+				n = n<<1 + d.bit(probs+2*stride)
+			}
+		}
+	}
+
+	return n
+}
+
+// LZRCDecompress decompresses the LZRC data of src into dst and returns the
+// size of the decompressed data.
+func LZRCDecompress(dst, src []byte) (int, error) {
+	if len(src) < lzrcHeaderSize {
+		return 0, errDecompressTruncated
+	}
+
+	lc := uint(src[0])
+	size := binary.BigEndian.Uint32(src[1:])
+
+	if lc&lzrcStored != 0 {
+		if int64(size) > int64(len(dst)) {
+			return 0, errDecompressOverflow
+		}
+
+		if int64(size) > int64(len(src)-lzrcHeaderSize) {
+			return 0, errDecompressTruncated
+		}
+
+		return copy(dst, src[lzrcHeaderSize:lzrcHeaderSize+int(size)]), nil
+	}
+
+	d := newRangeDecoder(src, size, lzrcProbsSize)
+
+	out := 0
+	state := 0
+	var last byte
+
+	for d.err == nil {
+		if d.bit(lzrcMatchProbs+state*8) == 0 {
+			// literal byte
+			if state > 0 {
+				state--
+			}
+
+			if out == len(dst) {
+				return out, errDecompressOverflow
+			}
+
+			dst[out] = byte(d.bitTree(lzrcLiteralProbs+int(last>>lc&7)*256, 0x100))
+			out++
+		} else {
+			// match, the unary length of the length bits comes first
+			lenBits := 0
+			for step := 1; step < 8; step++ {
+				if d.bit(lzrcMatchProbs+state*8+step) == 0 {
+					break
+				}
+
+				lenBits++
+			}
+
+			length := 1
+			if lenBits > 0 {
+				lenState := (lenBits-1)<<2 + (out<<uint(lenBits-1))&3
+				length = d.number(lzrcLenProbs+state*31+lenState, 1, 3, lenBits)
+				if length == lzrcEndMarker {
+					break
+				}
+			}
+
+			distState, limit := 0, 8
+			if length > 2 {
+				distState, limit = 7, 44
+			}
+
+			distBits := d.bitTree(lzrcDistBitsProbs+lenBits*39+distState, limit) - limit
+
+			dist := 1
+			if distBits > 0 {
+				dist = d.number(lzrcDistProbs+distBits*8, 1, 3, distBits)
+			}
+
+			if dist > out {
+				return out, errDecompressDistance
+			}
+
+			if out+length+1 > len(dst) {
+				return out, errDecompressOverflow
+			}
+
+			// the copy may overlap
+			for i := 0; i <= length; i++ {
+				dst[out] = dst[out-dist]
+				out++
+			}
+
+			state = 6 + (out+1)&1
+		}
+
+		last = dst[out-1]
+	}
+
+	return out, d.err
+}
@@ -0,0 +1,294 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/bits"
+	"testing"
+)
+
+// rangeEncoder is the encoder of the binary range coder shared by LZRC and
+// the EDAT compression, normalizing before every bit like the decoders.
+type rangeEncoder struct {
+	out       []byte
+	low       uint64
+	rng       uint32
+	cache     byte
+	cacheSize int
+	probs     []byte
+}
+
+func newRangeEncoder(probs int) *rangeEncoder {
+	e := &rangeEncoder{rng: 0xffffffff, cacheSize: 1, probs: make([]byte, probs)}
+	for i := range e.probs {
+		e.probs[i] = 0x80
+	}
+
+	return e
+}
+
+func (e *rangeEncoder) shiftLow() {
+	if uint32(e.low) < 0xff000000 || e.low>>32 != 0 {
+		carry := byte(e.low >> 32)
+		temp := e.cache
+		for ; e.cacheSize > 0; e.cacheSize-- {
+			e.out = append(e.out, temp+carry)
+			temp = 0xff
+		}
+
+		e.cache = byte(e.low >> 24)
+	}
+
+	e.cacheSize++
+	e.low = (e.low & 0x00ffffff) << 8
+}
+
+func (e *rangeEncoder) normalize() {
+	if e.rng < 1<<24 {
+		e.rng <<= 8
+		e.shiftLow()
+	}
+}
+
+func (e *rangeEncoder) bit(prob, b int) {
+	e.normalize()
+
+	p := &e.probs[prob]
+	bound := (e.rng >> 8) * uint32(*p)
+	*p -= *p >> 3
+
+	if b == 1 {
+		e.rng = bound
+		*p += 31
+	} else {
+		e.low += uint64(bound)
+		e.rng -= bound
+	}
+}
+
+func (e *rangeEncoder) direct(b int) {
+	e.rng >>= 1
+	if b == 0 {
+		e.low += uint64(e.rng)
+	}
+}
+
+// bitTree codes the bits of value after the leading 1.
+func (e *rangeEncoder) bitTree(probs, value int) {
+	n := 1
+	for i := bits.Len(uint(value)) - 2; i >= 0; i-- {
+		b := value >> uint(i) & 1
+		e.bit(probs+n, b)
+		n = n<<1 + b
+	}
+}
+
+// number mirrors rangeDecoder.number.
+func (e *rangeEncoder) number(probs, stride, upper, nbits, value int) {
+	next := func() int {
+		nbits--
+		return value >> uint(nbits) & 1
+	}
+
+	n := nbits
+	if n > 3 {
+		e.bit(probs+upper, next())
+		if n > 4 {
+			e.bit(probs+upper, next())
+			if n > 5 {
+				e.normalize()
+				for i := 0; i < n-5; i++ {
+					e.direct(next())
+				}
+			}
+		}
+	}
+
+	if n > 0 {
+		e.bit(probs, next())
+		if n > 1 {
+			e.bit(probs+stride, next())
+			if n > 2 {
+				e.bit(probs+2*stride, next())
+			}
+		}
+	}
+}
+
+// finish flushes the encoder and returns the stream with the header byte,
+// the decoders read the code from the header.
+func (e *rangeEncoder) finish(header byte) []byte {
+	for i := 0; i < 5; i++ {
+		e.shiftLow()
+	}
+
+	e.out[0] = header
+	return e.out
+}
+
+// findMatch returns the longest previous match of data[pos:], searching a
+// small window.
+func findMatch(data []byte, pos, maxLength, maxDist int) (length, dist int) {
+	for d := 1; d <= maxDist && d <= pos; d++ {
+		l := 0
+		for l < maxLength && pos+l < len(data) && data[pos+l] == data[pos+l-d] {
+			l++
+		}
+
+		if l > length {
+			length, dist = l, d
+		}
+	}
+
+	return
+}
+
+// lzrcCompress is a greedy LZRC compressor, the test counterpart of
+// LZRCDecompress.
+func lzrcCompress(data []byte, lc uint) []byte {
+	e := newRangeEncoder(lzrcProbsSize)
+
+	state := 0
+	var last byte
+
+	for pos := 0; pos <= len(data); {
+		length, dist := findMatch(data, pos, 0xff, 0x1000)
+		// the short matches only code distances of up to 7 bits
+		if length <= 3 && dist >= 0x100 {
+			length = 0
+		}
+
+		switch {
+		case pos == len(data):
+			// end marker, a match of the maximum length bits
+			e.bit(lzrcMatchProbs+state*8, 1)
+			for step := 1; step < 8; step++ {
+				e.bit(lzrcMatchProbs+state*8+step, 1)
+			}
+
+			lenState := 6<<2 + (pos<<6)&3
+			e.number(lzrcLenProbs+state*31+lenState, 1, 3, 7, lzrcEndMarker)
+			pos++
+		case length < 2:
+			e.bit(lzrcMatchProbs+state*8, 0)
+			if state > 0 {
+				state--
+			}
+
+			e.bitTree(lzrcLiteralProbs+int(last>>lc&7)*256, 0x100|int(data[pos]))
+			pos++
+		default:
+			e.bit(lzrcMatchProbs+state*8, 1)
+
+			matchLen := length - 1
+			lenBits := bits.Len(uint(matchLen)) - 1
+			for step := 1; step < 8; step++ {
+				b := 0
+				if step <= lenBits {
+					b = 1
+				}
+
+				e.bit(lzrcMatchProbs+state*8+step, b)
+				if b == 0 {
+					break
+				}
+			}
+
+			if lenBits > 0 {
+				lenState := (lenBits-1)<<2 + (pos<<uint(lenBits-1))&3
+				e.number(lzrcLenProbs+state*31+lenState, 1, 3, lenBits, matchLen)
+			}
+
+			distState, limit := 0, 8
+			if matchLen > 2 {
+				distState, limit = 7, 44
+			}
+
+			distBits := 0
+			if dist > 1 {
+				distBits = bits.Len(uint(dist)) - 1
+			}
+
+			e.bitTree(lzrcDistBitsProbs+lenBits*39+distState, distBits+limit)
+			if distBits > 0 {
+				e.number(lzrcDistProbs+distBits*8, 1, 3, distBits, dist)
+			}
+
+			pos += length
+			state = 6 + (pos+1)&1
+		}
+
+		if pos > 0 && pos <= len(data) {
+			last = data[pos-1]
+		}
+	}
+
+	return e.finish(byte(lc))
+}
+
+// testText returns compressible data, text with random bytes in between.
+func testText(size int) []byte {
+	words := [][]byte{[]byte("NPUMDIMG "), []byte("LZRC "), []byte("range coder "), []byte("PSP ")}
+	noise := randomData(size)
+
+	var buf bytes.Buffer
+	for idx := 0; buf.Len() < size; idx++ {
+		if noise[idx]&3 == 0 {
+			buf.Write(noise[idx : idx+1+int(noise[idx+1]&7)])
+		} else {
+			buf.Write(words[int(noise[idx])%len(words)])
+		}
+	}
+
+	return buf.Bytes()[:size]
+}
+
+func TestLZRCDecompress(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"text", testText(20000)},
+		{"random", randomData(3000)},
+		{"zeros", make([]byte, 5000)},
+		{"single byte", []byte{1}},
+	}
+
+	for _, tt := range tests {
+		compressed := lzrcCompress(tt.data, 5)
+
+		out := make([]byte, len(tt.data))
+		n, err := LZRCDecompress(out, compressed)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if !bytes.Equal(out[:n], tt.data) {
+			t.Fatalf("%s: the decompressed data doesn't match", tt.name)
+		}
+
+		// the output buffer must be large enough
+		if len(tt.data) > 1 {
+			if _, err := LZRCDecompress(out[:len(tt.data)-1], compressed); err != errDecompressOverflow {
+				t.Fatalf("%s: short output returned %v", tt.name, err)
+			}
+		}
+	}
+}
+
+func TestLZRCStored(t *testing.T) {
+	data := []byte("stored data")
+	src := make([]byte, lzrcHeaderSize)
+	src[0] = lzrcStored
+	binary.BigEndian.PutUint32(src[1:], uint32(len(data)))
+
+	out := make([]byte, 100)
+	n, err := LZRCDecompress(out, append(src, data...))
+	if err != nil || !bytes.Equal(out[:n], data) {
+		t.Fatalf("got %q, %v", out[:n], err)
+	}
+
+	if _, err := LZRCDecompress(out, src); err != errDecompressTruncated {
+		t.Fatalf("truncated data returned %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var npumdimgMagic = []byte("NPUMDIMG")
+
+const (
+	npumdimgHeaderSize = 0x100
+	// the data of the block isn't encrypted
+	npumdimgBlockPlain = 4
+	isoSectorSize      = 2048
+	// area of the header covered by the MAC, the MAC follows it
+	npumdimgMacSize = 0xc0
+	// encrypted area of the header, with the header key after it
+	npumdimgEncryptedOffset = 0x40
+	npumdimgEncryptedSize   = 0x60
+	npumdimgHeaderKeyOffset = 0xa0
+)
+
+type npumdimgTableEntry struct {
+	Mac     [16]byte
+	Offset  uint32
+	Size    uint32
+	Flags   uint32
+	Unknown uint32
+}
+
+// An NPUMDIMG is the encrypted ISO image stored in the DATA.PSAR section of
+// PSP EBOOT.PBP files.
+type NPUMDIMG struct {
+	r          io.ReaderAt
+	headerKey  []byte
+	versionKey []byte
+	blockSize  int64
+	size       int64
+	table      []npumdimgTableEntry
+}
+
+// ConvertPBPToISO writes the ISO image stored in the DATA.PSAR section of a
+// PSP EBOOT.PBP.
+func ConvertPBPToISO(r io.ReaderAt, size int64, w io.Writer) error {
+	img, err := OpenPBPImage(r, size)
+	if err != nil {
+		return err
+	}
+
+	_, err = img.WriteTo(w)
+	return err
+}
+
+// ConvertNPUMDIMG decrypts and decompresses an NPUMDIMG image into a plain ISO.
+func ConvertNPUMDIMG(r io.ReaderAt, w io.Writer) error {
+	img, err := OpenNPUMDIMG(r)
+	if err != nil {
+		return err
+	}
+
+	_, err = img.WriteTo(w)
+	return err
+}
+
+// OpenPBPImage opens the NPUMDIMG image of a PSP EBOOT.PBP.
+func OpenPBPImage(r io.ReaderAt, size int64) (*NPUMDIMG, error) {
+	p, err := ParsePBP(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	return OpenNPUMDIMG(p.Section(PBPDataPSAR))
+}
+
+// OpenNPUMDIMG reads the header and the block table of an NPUMDIMG image.
+func OpenNPUMDIMG(r io.ReaderAt) (*NPUMDIMG, error) {
+	header := make([]byte, npumdimgHeaderSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(header[:len(npumdimgMagic)], npumdimgMagic) {
+		return nil, errors.New("invalid NPUMDIMG header")
+	}
+
+	// the version key is protected by the MAC of the header
+	versionKey, err := bbVersionKey(bbMacType3, header[:npumdimgMacSize], header[npumdimgMacSize:])
+	if err != nil {
+		return nil, err
+	}
+
+	headerKey := header[npumdimgHeaderKeyOffset : npumdimgHeaderKeyOffset+16]
+	c, err := newBBCipher(headerKey, versionKey, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := header[npumdimgEncryptedOffset : npumdimgEncryptedOffset+npumdimgEncryptedSize]
+	c.XORKeyStream(encrypted, encrypted)
+
+	blockLBAs := int64(binary.LittleEndian.Uint32(header[0x0c:]))
+	lbaStart := int64(binary.LittleEndian.Uint32(header[0x54:]))
+	lbaEnd := int64(binary.LittleEndian.Uint32(header[0x64:]))
+	tableOffset := int64(binary.LittleEndian.Uint32(header[0x6c:]))
+
+	if blockLBAs == 0 || lbaEnd < lbaStart {
+		return nil, errors.New("invalid NPUMDIMG header")
+	}
+
+	lbaSize := lbaEnd - lbaStart + 1
+	numBlocks := (lbaSize + blockLBAs - 1) / blockLBAs
+
+	table := make([]npumdimgTableEntry, numBlocks)
+	err = binary.Read(io.NewSectionReader(r, tableOffset, numBlocks*32), binary.LittleEndian, table)
+	if err != nil {
+		return nil, err
+	}
+
+	for idx := range table {
+		unscrambleTableEntry(&table[idx])
+	}
+
+	return &NPUMDIMG{
+		r:          r,
+		headerKey:  headerKey,
+		versionKey: versionKey,
+		blockSize:  blockLBAs * isoSectorSize,
+		size:       lbaSize * isoSectorSize,
+		table:      table,
+	}, nil
+}
+
+// Size returns the size of the decrypted ISO image.
+func (img *NPUMDIMG) Size() int64 {
+	return img.size
+}
+
+// WriteTo writes the decrypted ISO image to w.
+func (img *NPUMDIMG) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	block := make([]byte, img.blockSize)
+
+	for _, entry := range img.table {
+		if int64(entry.Size) > img.blockSize {
+			return written, errors.New("invalid NPUMDIMG block size")
+		}
+
+		data := make([]byte, entry.Size)
+		if _, err := img.r.ReadAt(data, int64(entry.Offset)); err != nil {
+			return written, err
+		}
+
+		if entry.Flags&npumdimgBlockPlain == 0 {
+			// the cipher seed is the position of the block
+			c, err := newBBCipher(img.headerKey, img.versionKey, entry.Offset>>4)
+			if err != nil {
+				return written, err
+			}
+
+			c.XORKeyStream(data, data)
+		}
+
+		out := data
+		if int64(entry.Size) < img.blockSize {
+			n, err := LZRCDecompress(block, data)
+			if err != nil {
+				return written, err
+			}
+
+			out = block[:n]
+		}
+
+		if remaining := img.size - written; int64(len(out)) > remaining {
+			out = out[:remaining]
+		}
+
+		n, err := w.Write(out)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// unscrambleTableEntry removes the XOR obfuscation of a block table entry.
+func unscrambleTableEntry(e *npumdimgTableEntry) {
+	var mac [4]uint32
+	for i := range mac {
+		mac[i] = binary.LittleEndian.Uint32(e.Mac[i*4:])
+	}
+
+	e.Offset ^= mac[2] ^ mac[3]
+	e.Size ^= mac[1] ^ mac[2]
+	e.Flags ^= mac[0] ^ mac[3]
+	e.Unknown ^= mac[0] ^ mac[1]
+}
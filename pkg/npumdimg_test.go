@@ -0,0 +1,192 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"testing"
+)
+
+// testNPUMDIMG encrypts an ISO image in an NPUMDIMG container, alternating
+// compressed, stored and plain blocks.
+func testNPUMDIMG(t *testing.T, iso []byte, blockLBAs int) []byte {
+	blockSize := blockLBAs * isoSectorSize
+	numBlocks := (len(iso) + blockSize - 1) / blockSize
+	tableOffset := npumdimgHeaderSize
+
+	headerKey := randomData(16)
+	versionKey := randomData(16)
+
+	header := make([]byte, npumdimgHeaderSize)
+	copy(header, npumdimgMagic)
+	copy(header[npumdimgHeaderKeyOffset:], headerKey)
+	binary.LittleEndian.PutUint32(header[0x0c:], uint32(blockLBAs))
+	binary.LittleEndian.PutUint32(header[0x54:], 0)
+	binary.LittleEndian.PutUint32(header[0x64:], uint32(len(iso)/isoSectorSize-1))
+	binary.LittleEndian.PutUint32(header[0x6c:], uint32(tableOffset))
+
+	data := make([]byte, tableOffset+numBlocks*32)
+	for idx := 0; idx < numBlocks; idx++ {
+		block := make([]byte, blockSize)
+		copy(block, iso[idx*blockSize:])
+
+		var flags uint32
+		stored := block
+		switch idx % 3 {
+		case 0:
+			stored = lzrcCompress(block, 5)
+		case 2:
+			stored = lzrcCompress(block, 5)
+			flags = npumdimgBlockPlain
+		}
+
+		if idx%3 != 1 && len(stored) >= blockSize {
+			t.Fatalf("block %d doesn't compress", idx)
+		}
+
+		offset := uint32(len(data))
+		if flags&npumdimgBlockPlain == 0 {
+			c, err := newBBCipher(headerKey, versionKey, offset>>4)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c.XORKeyStream(stored, stored)
+		}
+
+		entry := npumdimgTableEntry{Offset: offset, Size: uint32(len(stored)), Flags: flags}
+		copy(entry.Mac[:], randomData(16))
+		unscrambleTableEntry(&entry)
+
+		var buf bytes.Buffer
+		binary.Write(&buf, binary.LittleEndian, entry)
+		copy(data[tableOffset+idx*32:], buf.Bytes())
+
+		// the blocks start on cipher blocks
+		data = append(data, stored...)
+		data = append(data, make([]byte, -len(data)&15)...)
+	}
+
+	c, err := newBBCipher(headerKey, versionKey, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted := header[npumdimgEncryptedOffset : npumdimgEncryptedOffset+npumdimgEncryptedSize]
+	c.XORKeyStream(encrypted, encrypted)
+
+	copy(header[npumdimgMacSize:], bbMac(t, bbMacType3, header[:npumdimgMacSize], versionKey))
+
+	copy(data, header)
+	return data
+}
+
+// bbMac is the MAC made by sceDrmBBMacFinal2 of the PSP, protecting
+// versionKey with the data.
+func bbMac(t *testing.T, macType int, data, versionKey []byte) []byte {
+	mac, err := AESCMAC(kirkKey38, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range mac {
+		mac[i] ^= amctrlMacMask[i] ^ versionKey[i]
+	}
+
+	for _, key := range [][]byte{kirkKey38, kirkKey63} {
+		block, _ := aes.NewCipher(key)
+		block.Encrypt(mac, mac)
+
+		if macType != bbMacType3 {
+			break
+		}
+	}
+
+	return mac
+}
+
+func TestBBVersionKey(t *testing.T) {
+	data := make([]byte, pgdMacSize)
+	for i := range data {
+		data[i] = byte(i*7 + 1)
+	}
+
+	versionKey := make([]byte, aes.BlockSize)
+	for i := range versionKey {
+		versionKey[i] = byte(0xa0 + i)
+	}
+
+	// MACs made by the sceDrmBBMacFinal2 of libkirk
+	tests := []struct {
+		macType int
+		bbmac   []byte
+	}{
+		{bbMacType1, []byte{
+			0xf0, 0x29, 0xe4, 0x66, 0x9e, 0xb4, 0x9b, 0xca,
+			0xcd, 0xe6, 0x89, 0x7b, 0xfe, 0xef, 0xbc, 0xc8,
+		}},
+		{bbMacType3, []byte{
+			0x92, 0xae, 0xab, 0x82, 0x27, 0x78, 0x38, 0x7f,
+			0xc2, 0x76, 0xb9, 0xe9, 0xfc, 0xa9, 0xa5, 0xbb,
+		}},
+	}
+
+	for _, tt := range tests {
+		if mac := bbMac(t, tt.macType, data, versionKey); !bytes.Equal(mac, tt.bbmac) {
+			t.Errorf("type %d: got MAC %x, want %x", tt.macType, mac, tt.bbmac)
+		}
+
+		key, err := bbVersionKey(tt.macType, data, tt.bbmac)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(key, versionKey) {
+			t.Errorf("type %d: got version key %x, want %x", tt.macType, key, versionKey)
+		}
+	}
+
+	if _, err := bbVersionKey(2, data, tests[0].bbmac); err != ErrBBMacType {
+		t.Errorf("type 2: got %v, want %v", err, ErrBBMacType)
+	}
+}
+
+func TestNPUMDIMG(t *testing.T) {
+	// a partial last block, with the random data of the image compressible
+	iso := testText(53 * isoSectorSize)
+	copy(iso[16*isoSectorSize:32*isoSectorSize], randomData(16*isoSectorSize))
+
+	pbp := testPBP(testNPUMDIMG(t, iso, 16))
+
+	img, err := OpenPBPImage(bytes.NewReader(pbp), int64(len(pbp)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if img.Size() != int64(len(iso)) {
+		t.Fatalf("got size %d, want %d", img.Size(), len(iso))
+	}
+
+	var out bytes.Buffer
+	if err := ConvertPBPToISO(bytes.NewReader(pbp), int64(len(pbp)), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(out.Bytes(), iso) {
+		t.Fatal("the ISO image doesn't match")
+	}
+}
+
+// testPBP returns an EBOOT.PBP with the data in the DATA.PSAR section.
+func testPBP(psar []byte) []byte {
+	header := pbpHeader{Magic: pbpMagic, Version: 0x10000}
+	for idx := range header.Offsets {
+		header.Offsets[idx] = uint32(binary.Size(header))
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, header)
+	buf.Write(psar)
+
+	return buf.Bytes()
+}
@@ -0,0 +1,116 @@
+package pkg
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"os"
+)
+
+// ErrHashMismatch is returned by Next at the end of the package when the
+// hash doesn't match and the reader uses HashRequire.
+var ErrHashMismatch = errors.New("pkg: PKG SHA1 check failed")
+
+// HashPolicy selects how the SHA1 of the whole package is handled.
+type HashPolicy int
+
+const (
+	// HashCheck calculates the hash, the result is reported by Valid
+	HashCheck HashPolicy = iota
+	// HashRequire makes Next fail with ErrHashMismatch if the hash doesn't match
+	HashRequire
+	// HashSkip doesn't calculate the hash, Valid always reports false
+	HashSkip
+)
+
+// ReaderOptions configures a Reader. The zero value uses the defaults of
+// NewReader without a license.
+type ReaderOptions struct {
+	// ZRIF is the license in zRIF format
+	ZRIF string
+	// RIF is the decoded license (the contents of a .rif or work.bin file),
+	// used instead of ZRIF
+	RIF []byte
+	// Keys used to decrypt the package, DefaultKeys if nil
+	Keys KeyProvider
+	// HashPolicy of the package SHA1, HashCheck by default
+	HashPolicy HashPolicy
+	// BufferSize of the reads from the underlying reader, zero doesn't
+	// buffer them
+	BufferSize int
+	// Logger receives diagnostic messages, nil disables them
+	Logger *log.Logger
+}
+
+// NewReaderWithOptions creates a Reader from r. A nil opts is the same as
+// the zero ReaderOptions.
+func NewReaderWithOptions(r io.Reader, opts *ReaderOptions) (*Reader, error) {
+	zr := new(Reader)
+	if err := zr.init(r, opts); err != nil {
+		return nil, err
+	}
+
+	return zr, nil
+}
+
+// OpenReaderWithOptions opens the named pkg file, see NewReaderWithOptions.
+func OpenReaderWithOptions(name string, opts *ReaderOptions) (*ReadCloser, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	r := new(ReadCloser)
+	if err := r.init(f, opts); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	r.f = f
+	return r, nil
+}
+
+// applyOptions sets up the reader, returning the input reader to use.
+func (pr *Reader) applyOptions(r io.Reader, opts *ReaderOptions) io.Reader {
+	if opts == nil {
+		opts = &ReaderOptions{}
+	}
+
+	pr.keys = opts.Keys
+	pr.hashPolicy = opts.HashPolicy
+	pr.logger = opts.Logger
+
+	if opts.BufferSize > 0 {
+		r = bufio.NewReaderSize(r, opts.BufferSize)
+	}
+
+	return r
+}
+
+// license returns the decoded license given in the options, if any.
+func (pr *Reader) license(opts *ReaderOptions) ([]byte, error) {
+	if opts == nil {
+		return nil, nil
+	}
+
+	if len(opts.RIF) > 0 {
+		if size := licenseSize(pr.PackageType()); size > 0 && len(opts.RIF) != size {
+			return nil, errors.New("invalid license length")
+		}
+
+		return opts.RIF, nil
+	}
+
+	if len(opts.ZRIF) > 0 {
+		return DecodeLicense(opts.ZRIF, pr.PackageType())
+	}
+
+	return nil, nil
+}
+
+func (pr *Reader) logf(format string, v ...interface{}) {
+	if pr.logger != nil {
+		pr.logger.Printf(format, v...)
+	}
+}
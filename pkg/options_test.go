@@ -0,0 +1,151 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"testing"
+)
+
+// readAll reads every entry of the package, returning the first error.
+func readAll(r *Reader) error {
+	for {
+		_, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.Copy(ioutil.Discard, r); err != nil {
+			return err
+		}
+	}
+}
+
+func TestHashPolicy(t *testing.T) {
+	data := readTestdata(t, testVitaPkg)
+	tampered := tamper(data, len(data)-fileHashSize)
+
+	tests := []struct {
+		name   string
+		data   []byte
+		policy HashPolicy
+		err    error
+		status CheckStatus
+	}{
+		{"check", data, HashCheck, nil, CheckOK},
+		{"check tampered", tampered, HashCheck, nil, CheckFailed},
+		{"require", data, HashRequire, nil, CheckOK},
+		{"require tampered", tampered, HashRequire, ErrHashMismatch, CheckFailed},
+		{"skip", data, HashSkip, nil, CheckSkipped},
+		{"skip tampered", tampered, HashSkip, nil, CheckSkipped},
+	}
+
+	for _, tt := range tests {
+		r, err := NewReaderWithOptions(bytes.NewReader(tt.data), &ReaderOptions{HashPolicy: tt.policy})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if err := readAll(r); err != tt.err {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+
+		report, err := r.VerifyTail()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if report.FileHash != tt.status {
+			t.Errorf("%s: file hash %s, want %s", tt.name, report.FileHash, tt.status)
+		}
+
+		if r.Valid() != (tt.status == CheckOK) {
+			t.Errorf("%s: Valid returned %t", tt.name, r.Valid())
+		}
+	}
+}
+
+func TestReaderOptionsLicense(t *testing.T) {
+	data := readTestdata(t, testVitaPkg)
+
+	if _, err := NewReaderWithOptions(bytes.NewReader(data), &ReaderOptions{RIF: testVitaRif()}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewReaderWithOptions(bytes.NewReader(data), &ReaderOptions{RIF: make([]byte, 100)}); err == nil {
+		t.Fatal("accepted a license of the wrong size")
+	}
+}
+
+// countingReader counts the reads made on the underlying reader.
+type countingReader struct {
+	r     io.Reader
+	reads int
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	c.reads++
+	return c.r.Read(b)
+}
+
+func TestReaderOptionsBufferSize(t *testing.T) {
+	data := readTestdata(t, testVitaPkg)
+
+	reads := map[int]int{}
+	for _, size := range []int{0, 0x10000} {
+		cr := &countingReader{r: bytes.NewReader(data)}
+		r, err := NewReaderWithOptions(cr, &ReaderOptions{BufferSize: size})
+		if err != nil {
+			t.Fatalf("buffer size %d: %v", size, err)
+		}
+
+		if err := readAll(r); err != nil || !r.Valid() {
+			t.Fatalf("buffer size %d: %v, valid %t", size, err, r.Valid())
+		}
+
+		reads[size] = cr.reads
+	}
+
+	if reads[0x10000] >= reads[0] {
+		t.Errorf("%d reads with a buffer, %d without it", reads[0x10000], reads[0])
+	}
+}
+
+func TestReaderOptionsLogger(t *testing.T) {
+	data := readTestdata(t, testVitaPkg)
+
+	var logs bytes.Buffer
+	logger := log.New(&logs, "", 0)
+
+	r, err := NewReaderWithOptions(bytes.NewReader(tamper(data, len(data)-fileHashSize)), &ReaderOptions{Logger: logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := readAll(r); err != nil {
+		t.Fatal(err)
+	}
+
+	want := testContentID + ": Vita App package, key type 2\n" +
+		fmt.Sprintf("%s: %d entries\n", testContentID, testVitaEntries) +
+		testContentID + ": PKG SHA1 check failed\n"
+	if logs.String() != want {
+		t.Errorf("got the messages\n%s\nwant\n%s", logs.String(), want)
+	}
+
+	// the messages are dropped without a logger
+	r, err = NewReaderWithOptions(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := readAll(r); err != nil {
+		t.Fatal(err)
+	}
+}
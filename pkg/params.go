@@ -0,0 +1,261 @@
+package pkg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Category is the kind of application, decoded from the CATEGORY parameter.
+type Category int
+
+const (
+	CategoryUnknown Category = iota
+	// CategoryGame is a digital game or application
+	CategoryGame
+	// CategoryDiscGame is a game from an UMD, a game card or a Blu-ray disc
+	CategoryDiscGame
+	// CategoryPatch is a game update
+	CategoryPatch
+	// CategoryAddCont is additional content of a game
+	CategoryAddCont
+	// CategoryGameData is the installed data or the update of a PS3 game
+	CategoryGameData
+	// CategorySaveData is a save of a game
+	CategorySaveData
+	// CategoryApp is a non game application
+	CategoryApp
+	// CategoryPSOne is a PSOne classic
+	CategoryPSOne
+	// CategoryPS2 is a PS2 classic
+	CategoryPS2
+	// CategoryMinis is a PSP or PS3 mini
+	CategoryMinis
+)
+
+// the category codes are lower case on the Vita and upper case on the PSP
+// and the PS3
+var categoryCodes = map[string]Category{
+	"gd":  CategoryGame,
+	"gc":  CategoryDiscGame,
+	"gp":  CategoryPatch,
+	"ac":  CategoryAddCont,
+	"sd":  CategorySaveData,
+	"gda": CategoryApp,
+	"gdb": CategoryApp,
+	"gdc": CategoryApp,
+	"gdd": CategoryApp,
+	"gde": CategoryApp,
+	"EG":  CategoryGame,
+	"MG":  CategoryGame,
+	"UG":  CategoryDiscGame,
+	"PG":  CategoryPatch,
+	"ME":  CategoryPSOne,
+	"MS":  CategorySaveData,
+	"HG":  CategoryGame,
+	"DG":  CategoryDiscGame,
+	"GD":  CategoryGameData,
+	"SD":  CategorySaveData,
+	"1P":  CategoryPSOne,
+	"2P":  CategoryPS2,
+	"MN":  CategoryMinis,
+	"AP":  CategoryApp,
+	"AM":  CategoryApp,
+	"AV":  CategoryApp,
+}
+
+func (c Category) String() string {
+	switch c {
+	case CategoryGame:
+		return "Game"
+	case CategoryDiscGame:
+		return "Disc game"
+	case CategoryPatch:
+		return "Patch"
+	case CategoryAddCont:
+		return "Additional content"
+	case CategoryGameData:
+		return "Game data"
+	case CategorySaveData:
+		return "Save data"
+	case CategoryApp:
+		return "Application"
+	case CategoryPSOne:
+		return "PSOne classic"
+	case CategoryPS2:
+		return "PS2 classic"
+	case CategoryMinis:
+		return "Minis"
+	default:
+		return "Unknown"
+	}
+}
+
+// ParseCategory decodes the value of the CATEGORY parameter.
+func ParseCategory(code string) Category {
+	return categoryCodes[code]
+}
+
+// A Version is an application or a firmware version, like 01.00 or 3.60.
+type Version struct {
+	Major int
+	Minor int
+}
+
+// ParseVersion decodes a version in the SFO format. Only the first two
+// digits of the minor version are kept, so the PS3 firmware 03.5500 is 3.55.
+func ParseVersion(s string) (Version, error) {
+	major, minor := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		major, minor = s[:i], s[i+1:]
+	}
+
+	var v Version
+	var err error
+
+	v.Major, err = strconv.Atoi(major)
+	if err != nil || v.Major < 0 {
+		return Version{}, fmt.Errorf("invalid version: %s", s)
+	}
+
+	if minor != "" {
+		minor = (minor + "0")[:2]
+		v.Minor, err = strconv.Atoi(minor)
+		if err != nil || v.Minor < 0 {
+			return Version{}, fmt.Errorf("invalid version: %s", s)
+		}
+	}
+
+	return v, nil
+}
+
+// Compare returns -1, 0 or 1 if v is lower, equal or greater than o.
+func (v Version) Compare(o Version) int {
+	switch {
+	case v.Major != o.Major:
+		if v.Major < o.Major {
+			return -1
+		}
+		return 1
+	case v.Minor != o.Minor:
+		if v.Minor < o.Minor {
+			return -1
+		}
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (v Version) IsZero() bool {
+	return v.Major == 0 && v.Minor == 0
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%02d", v.Major, v.Minor)
+}
+
+// Attribute holds the flags of the ATTRIBUTE parameter. Their meaning depends
+// on the platform, only the PS3 ones are named. The PSP and Vita flags aren't
+// documented well enough to name them, they are only exposed through Bits.
+type Attribute uint32
+
+const (
+	AttributePS3RemotePlayV1   Attribute = 0x00000001
+	AttributePS3PSPExport      Attribute = 0x00000002
+	AttributePS3RemotePlayV2   Attribute = 0x00000004
+	AttributePS3XMBInGameForce Attribute = 0x00000008
+	AttributePS3XMBInGameOff   Attribute = 0x00000010
+	AttributePS3CustomMusic    Attribute = 0x00000020
+)
+
+// Has reports whether all the flags are set.
+func (a Attribute) Has(flags Attribute) bool {
+	return a&flags == flags
+}
+
+// Bits returns the numbers of the bits set, starting at 0.
+func (a Attribute) Bits() []int {
+	var bits []int
+	for bit := 0; bit < 32; bit++ {
+		if a&(1<<uint(bit)) != 0 {
+			bits = append(bits, bit)
+		}
+	}
+
+	return bits
+}
+
+// AppParams is a typed view of the common parameters of the PSP, PS3 and Vita
+// SFO files. The missing parameters keep the zero value.
+type AppParams struct {
+	Category Category
+	// CategoryCode is the raw CATEGORY value
+	CategoryCode string
+	// TitleID is the TITLE_ID, or the DISC_ID of the PSP games without it
+	TitleID   string
+	ContentID string
+	// AppVersion is the APP_VER of the game, the version of the patches
+	AppVersion Version
+	// Version is the VERSION of the data, used by the PS3 and PSP games
+	Version Version
+	// SystemVersion is the minimum firmware needed to run the application
+	SystemVersion Version
+	Attribute     Attribute
+	ParentalLevel int
+}
+
+// NewAppParams decodes the parameters of the SFO. The values that can't be
+// decoded are left empty.
+func NewAppParams(sfo *SFO) *AppParams {
+	params := &AppParams{}
+	if sfo == nil {
+		return params
+	}
+
+	params.CategoryCode = sfo.stringValue("CATEGORY")
+	params.Category = ParseCategory(params.CategoryCode)
+	params.TitleID = sfo.stringValue("TITLE_ID")
+	if params.TitleID == "" {
+		params.TitleID = sfo.stringValue("DISC_ID")
+	}
+	params.ContentID = sfo.stringValue("CONTENT_ID")
+	params.AppVersion, _ = ParseVersion(sfo.stringValue("APP_VER"))
+	params.Version, _ = ParseVersion(sfo.stringValue("VERSION"))
+	params.Attribute = Attribute(sfo.intValue("ATTRIBUTE"))
+	params.ParentalLevel = int(sfo.intValue("PARENTAL_LEVEL"))
+
+	if p := sfo.Get("PSP2_SYSTEM_VER"); p != nil && p.Format == SFOFormatInteger {
+		// the digits of the Vita firmware are stored as hex, 0x03600000 is 3.60
+		ver := fmt.Sprintf("%08x", p.Int())
+		params.SystemVersion, _ = ParseVersion(ver[:2] + "." + ver[2:4])
+	} else if ver := sfo.stringValue("PSP_SYSTEM_VER"); ver != "" {
+		params.SystemVersion, _ = ParseVersion(ver)
+	} else if ver := sfo.stringValue("PS3_SYSTEM_VER"); ver != "" {
+		params.SystemVersion, _ = ParseVersion(ver)
+	}
+
+	return params
+}
+
+// AppParams returns the decoded parameters of the package SFO. They are
+// empty if the package has no SFO or it wasn't read yet.
+func (pr *Reader) AppParams() *AppParams {
+	return NewAppParams(pr.SFO)
+}
+
+func (s *SFO) stringValue(key string) string {
+	if p := s.Get(key); p != nil && p.Format != SFOFormatInteger {
+		return strings.TrimRight(p.String(), "\x00")
+	}
+
+	return ""
+}
+
+func (s *SFO) intValue(key string) uint32 {
+	if p := s.Get(key); p != nil {
+		return p.Int()
+	}
+
+	return 0
+}
@@ -0,0 +1,180 @@
+package pkg
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		in    string
+		major int
+		minor int
+	}{
+		{"01.00", 1, 0},
+		{"1.05", 1, 5},
+		{"3.60", 3, 60},
+		// the PS3 versions have four minor digits
+		{"03.5500", 3, 55},
+		{"04.8000", 4, 80},
+		// a single minor digit is in tenths
+		{"6.6", 6, 60},
+		{"2", 2, 0},
+	}
+
+	for _, tt := range tests {
+		v, err := ParseVersion(tt.in)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.in, err)
+		}
+
+		if v.Major != tt.major || v.Minor != tt.minor {
+			t.Errorf("%s: got %d.%d", tt.in, v.Major, v.Minor)
+		}
+	}
+
+	for _, in := range []string{"", ".", "a.00", "1.x0", "-1.00", "1.-5"} {
+		if _, err := ParseVersion(in); err == nil {
+			t.Errorf("parsed the invalid version %q", in)
+		}
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		cmp  int
+	}{
+		{"01.00", "1.0", 0},
+		{"01.00", "01.01", -1},
+		{"1.50", "1.5", 0},
+		{"2.00", "1.99", 1},
+		{"3.60", "3.65", -1},
+		{"03.5500", "3.55", 0},
+	}
+
+	for _, tt := range tests {
+		a, _ := ParseVersion(tt.a)
+		b, _ := ParseVersion(tt.b)
+		if cmp := a.Compare(b); cmp != tt.cmp {
+			t.Errorf("%s vs %s = %d, want %d", tt.a, tt.b, cmp, tt.cmp)
+		}
+	}
+
+	if v, _ := ParseVersion("3.6"); v.String() != "3.60" {
+		t.Errorf("got %s", v)
+	}
+
+	if !(Version{}).IsZero() {
+		t.Error("the zero version isn't zero")
+	}
+}
+
+func TestParseCategory(t *testing.T) {
+	tests := []struct {
+		code     string
+		category Category
+	}{
+		{"gd", CategoryGame},
+		{"gp", CategoryPatch},
+		{"gdc", CategoryApp},
+		// the PSN games of the PSP
+		{"EG", CategoryGame},
+		{"MG", CategoryGame},
+		{"PG", CategoryPatch},
+		{"ME", CategoryPSOne},
+		{"HG", CategoryGame},
+		{"GD", CategoryGameData},
+		{"1P", CategoryPSOne},
+		{"2P", CategoryPS2},
+		{"MN", CategoryMinis},
+		// the codes are case sensitive
+		{"GP", CategoryUnknown},
+		{"", CategoryUnknown},
+	}
+
+	for _, tt := range tests {
+		if c := ParseCategory(tt.code); c != tt.category {
+			t.Errorf("%q: got %s, want %s", tt.code, c, tt.category)
+		}
+	}
+}
+
+func TestAttribute(t *testing.T) {
+	a := AttributePS3RemotePlayV1 | AttributePS3CustomMusic | 0x80000000
+
+	if !a.Has(AttributePS3RemotePlayV1|AttributePS3CustomMusic) ||
+		a.Has(AttributePS3PSPExport|AttributePS3RemotePlayV1) {
+		t.Error("wrong Has result")
+	}
+
+	bits := a.Bits()
+	if len(bits) != 3 || bits[0] != 0 || bits[1] != 5 || bits[2] != 31 {
+		t.Errorf("got bits %v", bits)
+	}
+
+	if Attribute(0).Bits() != nil {
+		t.Error("got bits of an empty attribute")
+	}
+}
+
+func TestNewAppParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		params []testSFOParam
+		check  func(p *AppParams) bool
+	}{
+		{"vita", testVitaSFO, func(p *AppParams) bool {
+			return p.Category == CategoryGame && p.ContentID == testContentID &&
+				p.AppVersion == Version{1, 0} && p.SystemVersion == Version{3, 60} &&
+				p.Attribute == 0x8000
+		}},
+		{"psp", testPSPSFO, func(p *AppParams) bool {
+			return p.Category == CategoryGame && p.CategoryCode == "EG" &&
+				p.TitleID == "ULUS00001" && p.SystemVersion == Version{6, 60}
+		}},
+		{"ps3", testPS3SFO, func(p *AppParams) bool {
+			return p.Category == CategoryGame && p.SystemVersion == Version{4, 80} &&
+				p.Version.IsZero() && p.ParentalLevel == 0
+		}},
+	}
+
+	for _, tt := range tests {
+		sfo := NewSFO()
+		if _, err := sfo.Parse(bytes.NewReader(rawSFO(tt.params, 0, 4, 0))); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if p := NewAppParams(sfo); !tt.check(p) {
+			t.Errorf("%s: got %+v", tt.name, p)
+		}
+	}
+
+	// the digits of the Vita firmware are hex, not the value
+	sfo := NewSFO()
+	sfo.Parse(bytes.NewReader(rawSFO(testVitaSFO, 0, 4, 0)))
+	sfo.Get("PSP2_SYSTEM_VER").SetInt(0x03650011)
+	if v := NewAppParams(sfo).SystemVersion; v != (Version{3, 65}) {
+		t.Errorf("got system version %s", v)
+	}
+
+	p, _ := sfo.Add("PARENTAL_LEVEL", SFOFormatInteger, 4)
+	p.SetInt(5)
+	p, _ = sfo.Add("VERSION", SFOFormatUTF8, 8)
+	p.SetString("01.02")
+
+	// the TITLE_ID is used before the DISC_ID
+	p, _ = sfo.Add("DISC_ID", SFOFormatUTF8, 16)
+	p.SetString("ULUS00001")
+	p, _ = sfo.Add("TITLE_ID", SFOFormatUTF8, 12)
+	p.SetString("PCSB00000")
+
+	params := NewAppParams(sfo)
+	if params.ParentalLevel != 5 || params.Version != (Version{1, 2}) || params.TitleID != "PCSB00000" {
+		t.Errorf("got %+v", params)
+	}
+
+	if params := NewAppParams(nil); *params != (AppParams{}) {
+		t.Errorf("got %+v without a SFO", params)
+	}
+}
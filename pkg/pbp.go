@@ -0,0 +1,77 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var pbpMagic = [4]byte{0x00, 0x50, 0x42, 0x50}
+
+type PBPSection int
+
+const (
+	PBPParamSFO PBPSection = iota
+	PBPIcon0
+	PBPIcon1
+	PBPPic0
+	PBPPic1
+	PBPSnd0
+	PBPDataPSP
+	PBPDataPSAR
+)
+
+type pbpHeader struct {
+	Magic   [4]byte
+	Version uint32
+	Offsets [8]uint32
+}
+
+// A PBP is an EBOOT.PBP container holding the PARAM.SFO, the icons and the
+// executable and data of a PSP or PSOne title.
+type PBP struct {
+	r      io.ReaderAt
+	size   int64
+	header pbpHeader
+}
+
+// ParsePBP reads the header of an EBOOT.PBP file.
+func ParsePBP(r io.ReaderAt, size int64) (*PBP, error) {
+	p := &PBP{r: r, size: size}
+
+	err := binary.Read(io.NewSectionReader(r, 0, size), binary.LittleEndian, &p.header)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(p.header.Magic[:], pbpMagic[:]) {
+		return nil, errors.New("invalid PBP header")
+	}
+
+	last := int64(binary.Size(p.header))
+	for _, offset := range p.header.Offsets {
+		if int64(offset) < last || int64(offset) > size {
+			return nil, errors.New("invalid PBP section offset")
+		}
+
+		last = int64(offset)
+	}
+
+	return p, nil
+}
+
+func (p *PBP) Version() uint32 {
+	return p.header.Version
+}
+
+// Section returns a reader for the given section, empty if it isn't present.
+func (p *PBP) Section(s PBPSection) *io.SectionReader {
+	start := int64(p.header.Offsets[s])
+	end := p.size
+	if s < PBPDataPSAR {
+		end = int64(p.header.Offsets[s+1])
+	}
+
+	return io.NewSectionReader(p.r, start, end-start)
+}
@@ -0,0 +1,50 @@
+package pkg
+
+import "io"
+
+// PackageInfo holds the metadata of a pkg that can be read without decrypting it.
+type PackageInfo struct {
+	ContentID   string
+	TitleID     string
+	Region      string
+	PackageType PackageType
+	ContentType ContentTypeEnum
+	DrmType     uint32
+	KeyType     int
+	TotalSize   int64
+	SfoEntries  map[string]string
+	SFO         *SFO
+}
+
+// Probe reads the headers, the metadata records and the embedded SFO of a pkg.
+// It stops before the encrypted data so only the start of the file is read.
+func Probe(r io.Reader) (*PackageInfo, error) {
+	pr := &Reader{reader: r}
+
+	cur, err := pr.readHeaders()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = pr.readInfo(cur); err != nil {
+		return nil, err
+	}
+
+	pkgType, err := pr.detectPackageType()
+	if err != nil {
+		return nil, err
+	}
+
+	return &PackageInfo{
+		ContentID:   pr.FileHeader.GetContentID(),
+		TitleID:     pr.FileHeader.GetTitleID(),
+		Region:      pr.GetRegion(),
+		PackageType: pkgType,
+		ContentType: pr.meta.ContentType,
+		DrmType:     pr.meta.DrmType,
+		KeyType:     pr.extendedHeader.KeyType(),
+		TotalSize:   pr.FileHeader.TotalSize,
+		SfoEntries:  pr.SfoEntries,
+		SFO:         pr.SFO,
+	}, nil
+}
@@ -0,0 +1,51 @@
+package pkg
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestProbe(t *testing.T) {
+	data := readTestdata(t, testVitaPkg)
+	ra := openTestPackage(t, data)
+
+	// only the data before the encrypted entries is available
+	info, err := Probe(bytes.NewReader(data[:ra.FileHeader.DataOffset]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.ContentID != testContentID || info.TitleID != "PCSB00000" {
+		t.Errorf("got content ID %q, title ID %q", info.ContentID, info.TitleID)
+	}
+
+	if info.Region != "EUR" {
+		t.Errorf("got region %q, want EUR", info.Region)
+	}
+
+	if info.PackageType != PackageTypeVitaApp || info.ContentType != ContentTypeVitaApp {
+		t.Errorf("got package type %d, content type %d", info.PackageType, info.ContentType)
+	}
+
+	if info.KeyType != 2 {
+		t.Errorf("got key type %d, want 2", info.KeyType)
+	}
+
+	if info.TotalSize != int64(len(data)) {
+		t.Errorf("got total size %d, want %d", info.TotalSize, len(data))
+	}
+
+	if info.SfoEntries["TITLE"] != testVitaTitle || info.SFO == nil {
+		t.Errorf("got SFO entries %v", info.SfoEntries)
+	}
+}
+
+func TestProbeTruncated(t *testing.T) {
+	data := readTestdata(t, testVitaPkg)
+
+	for _, size := range []int{0, 0x20, 0xc0} {
+		if _, err := Probe(bytes.NewReader(data[:size])); err == nil {
+			t.Errorf("probed a package of %d bytes", size)
+		}
+	}
+}
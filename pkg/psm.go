@@ -0,0 +1,41 @@
+package pkg
+
+import (
+	"encoding/xml"
+	"io"
+)
+
+// psmAppInfo is the name of the PSM application metadata, extracted to
+// RO/Application/app.info. PSM packages have no PARAM.SFO.
+const psmAppInfo = "contents/Application/app.info"
+
+// psmApplication is the part of the app.info XML file holding the title.
+type psmApplication struct {
+	DefaultLocale string `xml:"default_locale,attr"`
+	Names         []struct {
+		Locale string `xml:"locale,attr"`
+		Value  string `xml:"value,attr"`
+	} `xml:"name>localized_item"`
+}
+
+// readPSMTitle returns the application name of a PSM app.info file, the one
+// of the default locale if there are several.
+func readPSMTitle(r io.Reader) (string, error) {
+	var app psmApplication
+	if err := xml.NewDecoder(r).Decode(&app); err != nil {
+		return "", err
+	}
+
+	title := ""
+	for _, name := range app.Names {
+		if name.Locale == app.DefaultLocale {
+			return name.Value, nil
+		}
+
+		if title == "" {
+			title = name.Value
+		}
+	}
+
+	return title, nil
+}
@@ -0,0 +1,492 @@
+package pkg
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+var (
+	psisoimgMagic   = []byte("PSISOIMG0000")
+	pstitleimgMagic = []byte("PSTITLEIMG000000")
+)
+
+const (
+	psxSectorSize = 2352
+	// sectors of every compressed block of the image
+	psxBlockSectors = 16
+	psxBlockSize    = psxBlockSectors * psxSectorSize
+	// the first 2 seconds of the disc aren't stored in the image
+	psxPregapFrames = 150
+
+	psisoDiscIDOffset = 0x400
+	psisoTOCOffset    = 0x800
+	psisoIndexOffset  = 0x4000
+	psisoDataOffset   = 0x100000
+	psisoTOCEntrySize = 10
+
+	pstitleDiscsOffset = 0x200
+	pstitleMaxDiscs    = 5
+)
+
+// ErrEncryptedPSISOIMG is returned for images whose header is encrypted with
+// the keys of a console. The headers of the official PSOne Classics are
+// encrypted with the fixed AMCTRL keys, they are decrypted while opening the
+// discs.
+var ErrEncryptedPSISOIMG = errors.New("pkg: PSISOIMG headers encrypted with console keys are not supported")
+
+type psisoIndexEntry struct {
+	Offset uint32
+	Length uint16
+	_      [26]byte
+}
+
+// A PSXTrack is a track of a PSOne disc image.
+type PSXTrack struct {
+	Number int
+	Audio  bool
+	// Start is the first sector of the track inside the BIN image
+	Start int64
+}
+
+// A PSXDisc is a disc image stored in a PSISOIMG container.
+type PSXDisc struct {
+	r       io.ReaderAt
+	ID      string
+	Tracks  []PSXTrack
+	index   []psisoIndexEntry
+	sectors int64
+}
+
+// OpenPBPDiscs returns the disc images of a PSOne EBOOT.PBP. Encrypted
+// headers are decrypted, see ErrEncryptedPSISOIMG.
+func OpenPBPDiscs(r io.ReaderAt, size int64) ([]*PSXDisc, error) {
+	p, err := ParsePBP(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	return OpenPSXDiscs(p.Section(PBPDataPSAR))
+}
+
+// OpenPSXDiscs reads a PSISOIMG or a multi-disc PSTITLEIMG container.
+func OpenPSXDiscs(r *io.SectionReader) ([]*PSXDisc, error) {
+	magic := make([]byte, len(pstitleimgMagic))
+	if _, err := r.ReadAt(magic, 0); err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(magic, psisoimgMagic) {
+		disc, err := openPSXDisc(r)
+		if err != nil {
+			return nil, err
+		}
+
+		return []*PSXDisc{disc}, nil
+	}
+
+	if !bytes.Equal(magic, pstitleimgMagic) {
+		return nil, errors.New("invalid PSOne image header")
+	}
+
+	offsets := make([]uint32, pstitleMaxDiscs)
+	err := binary.Read(io.NewSectionReader(r, pstitleDiscsOffset, 4*pstitleMaxDiscs), binary.LittleEndian, offsets)
+	if err != nil {
+		return nil, err
+	}
+
+	var discs []*PSXDisc
+	for _, offset := range offsets {
+		if offset == 0 {
+			break
+		}
+
+		disc, err := openPSXDisc(io.NewSectionReader(r, int64(offset), r.Size()-int64(offset)))
+		if err != nil {
+			return nil, err
+		}
+
+		discs = append(discs, disc)
+	}
+
+	if len(discs) == 0 {
+		return nil, errors.New("PSTITLEIMG has no discs")
+	}
+
+	return discs, nil
+}
+
+func openPSXDisc(r io.ReaderAt) (*PSXDisc, error) {
+	header := make([]byte, psisoDataOffset)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(header, psisoimgMagic) {
+		return nil, errors.New("invalid PSISOIMG header")
+	}
+
+	// the official images encrypt the disc ID, the TOC and the index in a
+	// PGD, its data has the layout of the plain headers
+	if bytes.HasPrefix(header[psisoDiscIDOffset:], pgdMagic) {
+		data, err := decryptPGD(header[psisoDiscIDOffset:])
+		if err == ErrBBMacType {
+			return nil, ErrEncryptedPSISOIMG
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		n := copy(header[psisoDiscIDOffset:], data)
+		copy(header[psisoDiscIDOffset+n:], make([]byte, len(header)-psisoDiscIDOffset-n))
+	}
+
+	// plain headers store the disc ID as _SLUS_00001
+	discID := header[psisoDiscIDOffset : psisoDiscIDOffset+16]
+	if discID[0] != '_' {
+		return nil, errors.New("invalid PSISOIMG disc ID")
+	}
+
+	if n := bytes.IndexByte(discID, 0); n >= 0 {
+		discID = discID[:n]
+	}
+
+	disc := &PSXDisc{r: r, ID: strings.Replace(string(discID), "_", "", -1)}
+
+	var leadOut int64
+	for pos := psisoTOCOffset; pos < psisoIndexOffset; pos += psisoTOCEntrySize {
+		entry := header[pos : pos+psisoTOCEntrySize]
+		point := entry[2]
+		if point == 0 {
+			break
+		}
+
+		start := msfToSector(entry[7], entry[8], entry[9])
+
+		switch {
+		case point == 0xa2:
+			leadOut = start
+		case point <= 0x99:
+			disc.Tracks = append(disc.Tracks, PSXTrack{
+				Number: int(bcdToInt(point)),
+				Audio:  entry[0]&0x40 == 0,
+				Start:  start,
+			})
+		}
+	}
+
+	for pos := psisoIndexOffset; pos < psisoDataOffset; pos += binary.Size(psisoIndexEntry{}) {
+		var entry psisoIndexEntry
+		binary.Read(bytes.NewReader(header[pos:]), binary.LittleEndian, &entry)
+		if entry.Length == 0 {
+			break
+		}
+
+		if entry.Length > psxBlockSize {
+			return nil, errors.New("invalid PSISOIMG block length")
+		}
+
+		disc.index = append(disc.index, entry)
+	}
+
+	disc.sectors = int64(len(disc.index)) * psxBlockSectors
+	if leadOut > 0 && leadOut < disc.sectors {
+		disc.sectors = leadOut
+	}
+
+	return disc, nil
+}
+
+// Size returns the size of the BIN image.
+func (d *PSXDisc) Size() int64 {
+	return d.sectors * psxSectorSize
+}
+
+// WriteTo writes the raw BIN image of the disc.
+func (d *PSXDisc) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	remaining := d.Size()
+
+	for _, entry := range d.index {
+		if remaining == 0 {
+			break
+		}
+
+		data := make([]byte, entry.Length)
+		if _, err := d.r.ReadAt(data, psisoDataOffset+int64(entry.Offset)); err != nil {
+			return written, err
+		}
+
+		if entry.Length < psxBlockSize {
+			block := make([]byte, psxBlockSize)
+			n, err := io.ReadFull(flate.NewReader(bytes.NewReader(data)), block)
+			if err != nil && err != io.ErrUnexpectedEOF {
+				return written, err
+			}
+
+			data = block[:n]
+		}
+
+		if int64(len(data)) > remaining {
+			data = data[:remaining]
+		}
+
+		n, err := w.Write(data)
+		written += int64(n)
+		remaining -= int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// WriteCue writes a cue sheet for the disc image stored in binName.
+func (d *PSXDisc) WriteCue(w io.Writer, binName string) error {
+	var cue bytes.Buffer
+
+	fmt.Fprintf(&cue, "FILE \"%s\" BINARY\n", binName)
+	for idx, track := range d.Tracks {
+		if track.Audio {
+			fmt.Fprintf(&cue, "  TRACK %02d AUDIO\n", track.Number)
+			if idx > 0 && track.Start >= psxPregapFrames {
+				fmt.Fprintf(&cue, "    INDEX 00 %s\n", sectorToMSF(track.Start-psxPregapFrames))
+			}
+		} else {
+			fmt.Fprintf(&cue, "  TRACK %02d MODE2/2352\n", track.Number)
+		}
+
+		fmt.Fprintf(&cue, "    INDEX 01 %s\n", sectorToMSF(track.Start))
+	}
+
+	_, err := cue.WriteTo(w)
+	return err
+}
+
+func bcdToInt(b byte) int64 {
+	return int64(b>>4)*10 + int64(b&0xf)
+}
+
+// msfToSector converts a BCD disc position to a sector of the BIN image.
+func msfToSector(m, s, f byte) int64 {
+	return (bcdToInt(m)*60+bcdToInt(s))*75 + bcdToInt(f) - psxPregapFrames
+}
+
+func sectorToMSF(sector int64) string {
+	return fmt.Sprintf("%02d:%02d:%02d", sector/75/60, sector/75%60, sector%75)
+}
+
+// UnpackBinCue converts the EBOOT.PBP files of a PSOne package into BIN/CUE
+// disc images written to outDir.
+func (pr *Reader) UnpackBinCue(outDir string) error {
+	if pr.PackageType() != PackageTypePSOne {
+		return errors.New("not a PSOne package")
+	}
+
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return err
+	}
+
+	return pr.unpackBinCue(&fsPkgWriter{basedir: outDir})
+}
+
+// CreateBinCueZip is like UnpackBinCue, writing the disc images to a zip in
+// outDir named like the one of CreateZip.
+func (pr *Reader) CreateBinCueZip(outDir string) error {
+	if pr.PackageType() != PackageTypePSOne {
+		return errors.New("not a PSOne package")
+	}
+
+	return pr.writeZip(outDir, "", pr.unpackBinCue)
+}
+
+func (pr *Reader) unpackBinCue(w pkgWriter) error {
+	// a bundle of several titles names every title after its folder
+	ebootCount := 0
+	for idx := range pr.index.itemRecords {
+		if isPSXEBOOT(&pr.index.itemRecords[idx]) {
+			ebootCount++
+		}
+	}
+
+	converted := 0
+
+	for {
+		entry, err := pr.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if !isPSXEBOOT(entry) {
+			continue
+		}
+
+		name := pr.GetTitle()
+		if name == "" || ebootCount > 1 {
+			name = path.Base(path.Dir(pr.entryPath(entry)))
+		}
+
+		if err := pr.convertPSXEBOOT(w, name); err != nil {
+			return err
+		}
+
+		converted++
+	}
+
+	if converted == 0 {
+		return errors.New("no EBOOT.PBP found in package")
+	}
+
+	return nil
+}
+
+func isPSXEBOOT(entry *Entry) bool {
+	return entry.IsFile() && path.Base(entry.name) == "EBOOT.PBP"
+}
+
+// convertPSXEBOOT writes the discs of the current EBOOT.PBP entry.
+func (pr *Reader) convertPSXEBOOT(w pkgWriter, name string) error {
+	// the PBP sections are read out of order so spool it first
+	tmp, err := ioutil.TempFile("", "eboot")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, pr)
+	if err != nil {
+		return err
+	}
+
+	discs, err := OpenPBPDiscs(tmp, size)
+	if err != nil {
+		return err
+	}
+
+	var playlist bytes.Buffer
+
+	for idx, disc := range discs {
+		base := discName(name, idx, len(discs))
+		if err := writeDisc(w, disc, base); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(&playlist, "%s.cue\n", base)
+	}
+
+	if len(discs) == 1 {
+		return nil
+	}
+
+	return w.CreateFile(name+".m3u", &playlist)
+}
+
+// discName returns the base file name of the disc idx of a title.
+func discName(name string, idx, count int) string {
+	if count == 1 {
+		return name
+	}
+
+	return fmt.Sprintf("%s (Disc %d)", name, idx+1)
+}
+
+// SFODiscID returns the DISC_ID stored in the SFO, the ID of the first disc.
+// See DiscIDs for the IDs of every disc.
+func (pr *Reader) SFODiscID() string {
+	return pr.SfoEntries["DISC_ID"]
+}
+
+// Discs opens the disc images of every EBOOT.PBP of a PSOne package. Only
+// the headers of the images are read.
+//
+// The disc metadata needs random access to the EBOOT.PBP entries, so it is
+// only available from a ReaderAt. A streaming Reader has the DISC_ID of the
+// first disc, see SFODiscID, the other discs are only known once
+// UnpackBinCue has read their images.
+func (ra *ReaderAt) Discs() ([]*PSXDisc, error) {
+	if ra.PackageType() != PackageTypePSOne {
+		return nil, errors.New("not a PSOne package")
+	}
+
+	var discs []*PSXDisc
+
+	for idx := range ra.index.itemRecords {
+		entry := &ra.index.itemRecords[idx]
+		if !isPSXEBOOT(entry) {
+			continue
+		}
+
+		sr, err := ra.OpenEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		found, err := OpenPBPDiscs(sr, sr.Size())
+		if err != nil {
+			return nil, err
+		}
+
+		discs = append(discs, found...)
+	}
+
+	return discs, nil
+}
+
+// DiscIDs returns the IDs of the discs of a PSOne package, in the order of
+// the converted images. The disc headers are read on every call, the
+// DISC_ID of the SFO is available without it, see SFODiscID.
+func (ra *ReaderAt) DiscIDs() ([]string, error) {
+	discs, err := ra.Discs()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(discs))
+	for idx, disc := range discs {
+		ids[idx] = disc.ID
+	}
+
+	return ids, nil
+}
+
+// DiscCount returns the number of discs of a PSOne package, see DiscIDs.
+func (ra *ReaderAt) DiscCount() (int, error) {
+	ids, err := ra.DiscIDs()
+	return len(ids), err
+}
+
+func writeDisc(w pkgWriter, disc *PSXDisc, base string) error {
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		_, err := disc.WriteTo(pipeWriter)
+		pipeWriter.CloseWithError(err)
+	}()
+
+	err := w.CreateFile(base+".bin", pipeReader)
+	pipeReader.CloseWithError(err)
+	if err != nil {
+		return err
+	}
+
+	var cue bytes.Buffer
+	if err := disc.WriteCue(&cue, base+".bin"); err != nil {
+		return err
+	}
+
+	return w.CreateFile(base+".cue", &cue)
+}
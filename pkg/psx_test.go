@@ -0,0 +1,369 @@
+package pkg
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+// testDisc is a disc image with a data track and an audio track.
+type testDisc struct {
+	id  string
+	bin []byte
+}
+
+func newTestDisc(id string, sectors int) *testDisc {
+	// random sectors, half empty so the blocks can be compressed
+	bin := randomData(sectors * psxSectorSize)
+	for pos := 0; pos < len(bin); pos += psxSectorSize {
+		copy(bin[pos+psxSectorSize/2:pos+psxSectorSize], make([]byte, psxSectorSize/2))
+	}
+
+	return &testDisc{id: id, bin: bin}
+}
+
+func intToBCD(n int64) byte {
+	return byte(n/10<<4 | n%10)
+}
+
+func tocEntry(control, point byte, sector int64) []byte {
+	frames := sector + psxPregapFrames
+	entry := make([]byte, psisoTOCEntrySize)
+	entry[0] = control
+	entry[2] = point
+	entry[7] = intToBCD(frames / 75 / 60)
+	entry[8] = intToBCD(frames / 75 % 60)
+	entry[9] = intToBCD(frames % 75)
+
+	return entry
+}
+
+// psisoimg returns the PSISOIMG container of the disc with a plain header:
+// the disc ID, the TOC and the block index, followed by the blocks.
+func (d *testDisc) psisoimg() []byte {
+	header := make([]byte, psisoDataOffset)
+	copy(header, psisoimgMagic)
+	copy(header[psisoDiscIDOffset:], "_"+d.id[:4]+"_"+d.id[4:])
+
+	sectors := int64(len(d.bin) / psxSectorSize)
+	toc := header[psisoTOCOffset:]
+	copy(toc, tocEntry(0x41, 0x01, 0))
+	copy(toc[psisoTOCEntrySize:], tocEntry(0x01, 0x02, sectors/2))
+	copy(toc[2*psisoTOCEntrySize:], tocEntry(0x01, 0xa2, sectors))
+
+	var data bytes.Buffer
+	for idx := 0; idx*psxBlockSize < len(d.bin); idx++ {
+		block := make([]byte, psxBlockSize)
+		copy(block, d.bin[idx*psxBlockSize:])
+
+		// every other block is stored uncompressed
+		stored := block
+		if idx%2 == 1 {
+			var buf bytes.Buffer
+			fw, _ := flate.NewWriter(&buf, flate.BestCompression)
+			fw.Write(block)
+			fw.Close()
+			stored = buf.Bytes()
+		}
+
+		entry := header[psisoIndexOffset+idx*binary.Size(psisoIndexEntry{}):]
+		binary.LittleEndian.PutUint32(entry, uint32(data.Len()))
+		binary.LittleEndian.PutUint16(entry[4:], uint16(len(stored)))
+		data.Write(stored)
+	}
+
+	return append(header, data.Bytes()...)
+}
+
+// encryptedPSISOIMG returns the PSISOIMG container of the disc with the
+// header encrypted in a PGD, like the official images.
+func (d *testDisc) encryptedPSISOIMG(t *testing.T, drmType uint32) []byte {
+	img := d.psisoimg()
+
+	// the data doesn't follow the header right away
+	size := 0x10000
+	dataOffset := pgdHeaderSize + 0x30
+	pgd := make([]byte, dataOffset+size)
+	copy(pgd, pgdMagic)
+	binary.LittleEndian.PutUint32(pgd[4:], 1)
+	binary.LittleEndian.PutUint32(pgd[8:], drmType)
+	copy(pgd[0x10:], randomData(16))
+	copy(pgd[0x30:], randomData(16))
+	binary.LittleEndian.PutUint32(pgd[0x44:], uint32(size))
+	binary.LittleEndian.PutUint32(pgd[0x48:], 0x400)
+	binary.LittleEndian.PutUint32(pgd[0x4c:], uint32(dataOffset))
+	copy(pgd[dataOffset:], img[psisoDiscIDOffset:])
+
+	versionKey := randomData(16)
+	c, err := newBBCipher(pgd[0x30:0x40], versionKey, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.XORKeyStream(pgd[dataOffset:], pgd[dataOffset:])
+
+	c, err = newBBCipher(pgd[0x10:0x20], versionKey, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.XORKeyStream(pgd[0x30:0x60], pgd[0x30:0x60])
+
+	copy(pgd[pgdMacSize:], bbMac(t, bbMacType1, pgd[:pgdMacSize], versionKey))
+
+	copy(img[psisoDiscIDOffset:psisoDataOffset], make([]byte, psisoDataOffset-psisoDiscIDOffset))
+	copy(img[psisoDiscIDOffset:], pgd)
+
+	return img
+}
+
+// testPSTitleImg returns a multi-disc PSTITLEIMG container.
+func testPSTitleImg(discs ...*testDisc) []byte {
+	data := make([]byte, 0x8000)
+	copy(data, pstitleimgMagic)
+
+	for idx, disc := range discs {
+		binary.LittleEndian.PutUint32(data[pstitleDiscsOffset+4*idx:], uint32(len(data)))
+		data = append(data, disc.psisoimg()...)
+	}
+
+	return data
+}
+
+func TestOpenPSXDiscs(t *testing.T) {
+	disc := newTestDisc("SLUS00001", 400)
+	pbp := testPBP(disc.psisoimg())
+
+	discs, err := OpenPBPDiscs(bytes.NewReader(pbp), int64(len(pbp)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(discs) != 1 || discs[0].ID != "SLUS00001" {
+		t.Fatalf("got %d discs", len(discs))
+	}
+
+	var bin bytes.Buffer
+	if _, err := discs[0].WriteTo(&bin); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(bin.Bytes(), disc.bin) {
+		t.Fatal("the BIN image doesn't match")
+	}
+
+	var cue bytes.Buffer
+	if err := discs[0].WriteCue(&cue, "game.bin"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `FILE "game.bin" BINARY
+  TRACK 01 MODE2/2352
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    INDEX 00 00:00:50
+    INDEX 01 00:02:50
+`
+	if cue.String() != expected {
+		t.Fatalf("wrong cue sheet:\n%s", cue.String())
+	}
+}
+
+func TestOpenPSXDiscsEncrypted(t *testing.T) {
+	disc := newTestDisc("SCUS94163", 100)
+	pbp := testPBP(disc.encryptedPSISOIMG(t, pgdDRMTypeFixed))
+
+	discs, err := OpenPBPDiscs(bytes.NewReader(pbp), int64(len(pbp)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(discs) != 1 || discs[0].ID != "SCUS94163" || len(discs[0].Tracks) != 2 {
+		t.Fatalf("wrong discs %+v", discs)
+	}
+
+	var bin bytes.Buffer
+	if _, err := discs[0].WriteTo(&bin); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(bin.Bytes(), disc.bin) {
+		t.Fatal("the BIN image doesn't match")
+	}
+
+	// the other DRM types need the console keys
+	pbp = testPBP(disc.encryptedPSISOIMG(t, 2))
+	if _, err := OpenPBPDiscs(bytes.NewReader(pbp), int64(len(pbp))); !errors.Is(err, ErrEncryptedPSISOIMG) {
+		t.Fatalf("got %v, want ErrEncryptedPSISOIMG", err)
+	}
+}
+
+func TestPSOneDiscs(t *testing.T) {
+	discs := []*testDisc{newTestDisc("SLUS00001", 40), newTestDisc("SLUS00002", 20)}
+	fsys := fstest.MapFS{
+		"USRDIR/CONTENT/EBOOT.PBP": {Data: testPBP(testPSTitleImg(discs...))},
+		"PARAM.SFO":                {Data: testSFO(t, map[string]string{"DISC_ID": "SLUS00001"})},
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter("UP9000-NPUF00000_00-0000000000000000", ContentTypePS1, 1)
+	if err := w.WriteFS(&buf, fsys); err != nil {
+		t.Fatal(err)
+	}
+
+	ra, err := OpenReaderAt(bytes.NewReader(buf.Bytes()), int64(buf.Len()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := ra.DiscIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ids) != 2 || ids[0] != "SLUS00001" || ids[1] != "SLUS00002" {
+		t.Fatalf("wrong disc IDs %v", ids)
+	}
+
+	if count, err := ra.DiscCount(); err != nil || count != 2 {
+		t.Fatalf("DiscCount = %d, %v, want 2", count, err)
+	}
+
+	if ra.SFODiscID() != "SLUS00001" {
+		t.Fatalf("got SFO disc ID %q", ra.SFODiscID())
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the streaming reader only has the disc ID of the SFO
+	if r.SFODiscID() != "SLUS00001" {
+		t.Fatalf("got SFO disc ID %q", r.SFODiscID())
+	}
+
+	dir := t.TempDir()
+	if err := r.UnpackBinCue(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	for idx, disc := range discs {
+		name := discName("NPUF00000", idx, len(discs))
+		data, err := ioutil.ReadFile(filepath.Join(dir, name+".bin"))
+		if err != nil || !bytes.Equal(data, disc.bin) {
+			t.Errorf("disc %d doesn't match: %v", idx+1, err)
+		}
+	}
+
+	playlist, err := ioutil.ReadFile(filepath.Join(dir, "NPUF00000.m3u"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(playlist) != "NPUF00000 (Disc 1).cue\nNPUF00000 (Disc 2).cue\n" {
+		t.Fatalf("wrong playlist:\n%s", playlist)
+	}
+}
+
+func TestCreateBinCueZip(t *testing.T) {
+	discs := []*testDisc{newTestDisc("SLUS00001", 40), newTestDisc("SLUS00002", 20)}
+	fsys := fstest.MapFS{
+		"USRDIR/CONTENT/EBOOT.PBP": {Data: testPBP(testPSTitleImg(discs...))},
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter("UP9000-NPUF00000_00-0000000000000000", ContentTypePS1, 1)
+	if err := w.WriteFS(&buf, fsys); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := r.CreateBinCueZip(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.OpenReader(filepath.Join(dir, "NPUF00000.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer zr.Close()
+
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		files[f.Name], err = ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for idx, disc := range discs {
+		name := discName("NPUF00000", idx, len(discs))
+		if !bytes.Equal(files[name+".bin"], disc.bin) {
+			t.Errorf("disc %d doesn't match", idx+1)
+		}
+
+		if !bytes.HasPrefix(files[name+".cue"], []byte("FILE \""+name+".bin\" BINARY\n")) {
+			t.Errorf("wrong cue sheet of disc %d:\n%s", idx+1, files[name+".cue"])
+		}
+	}
+
+	if string(files["NPUF00000.m3u"]) != "NPUF00000 (Disc 1).cue\nNPUF00000 (Disc 2).cue\n" {
+		t.Fatalf("wrong playlist:\n%s", files["NPUF00000.m3u"])
+	}
+}
+
+func TestUnpackBinCueBundle(t *testing.T) {
+	discs := []*testDisc{newTestDisc("SLUS00001", 40), newTestDisc("SLUS00002", 20)}
+	fsys := fstest.MapFS{
+		"USRDIR/GAME1/EBOOT.PBP": {Data: testPBP(discs[0].psisoimg())},
+		"USRDIR/GAME2/EBOOT.PBP": {Data: testPBP(discs[1].psisoimg())},
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter("UP9000-NPUF00000_00-0000000000000000", ContentTypePS1, 1)
+	if err := w.WriteFS(&buf, fsys); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := r.UnpackBinCue(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// every title of a bundle is named after its folder, the first one too
+	for idx, name := range []string{"GAME1", "GAME2"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name+".bin"))
+		if err != nil || !bytes.Equal(data, discs[idx].bin) {
+			t.Errorf("%s doesn't match: %v", name, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "NPUF00000.bin")); err == nil {
+		t.Error("a title was named after the package")
+	}
+}
@@ -0,0 +1,125 @@
+package pkg
+
+import (
+	"crypto/aes"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	rapSize    = 0x10
+	ps3RifSize = 0x98
+	// offsets of the encrypted act.dat key index and klicensee of a PS3 rif
+	ps3RifIndexOffset = 0x40
+	ps3RifKeyOffset   = 0x50
+	// the act.dat key table starts after its header
+	actDatKeysOffset = 0x10
+	actDatKeyCount   = 0x80
+)
+
+// RAPToKlicensee converts the contents of a .rap file into the klicensee
+// used to decrypt the EDAT files of the content.
+func RAPToKlicensee(rap []byte) ([]byte, error) {
+	if len(rap) != rapSize {
+		return nil, errors.New("invalid RAP size")
+	}
+
+	c, err := aes.NewCipher(KeyRAP)
+	if err != nil {
+		return nil, err
+	}
+
+	key := make([]byte, rapSize)
+	c.Decrypt(key, rap)
+
+	for round := 0; round < 5; round++ {
+		for _, p := range rapPbox {
+			key[p] ^= rapE1[p]
+		}
+
+		for i := len(rapPbox) - 1; i > 0; i-- {
+			key[rapPbox[i]] ^= key[rapPbox[i-1]]
+		}
+
+		// subtract rapE2 with borrow, following the order of the pbox
+		var borrow byte
+		for _, p := range rapPbox {
+			kc := key[p] - borrow
+			if borrow == 0 || kc != 0xff {
+				if kc < rapE2[p] {
+					borrow = 1
+				} else {
+					borrow = 0
+				}
+			}
+
+			key[p] = kc - rapE2[p]
+		}
+	}
+
+	return key, nil
+}
+
+// RIFToKlicensee decrypts the klicensee of a PS3 .rif file. Unlike the RAP
+// files the rif is bound to the console so the act.dat of the account and
+// the IDPS of the console are needed too.
+func RIFToKlicensee(rif, actDat, idps []byte) ([]byte, error) {
+	if len(rif) != ps3RifSize {
+		return nil, errors.New("invalid PS3 rif size")
+	}
+
+	if len(idps) != aes.BlockSize {
+		return nil, errors.New("invalid IDPS size")
+	}
+
+	c, err := aes.NewCipher(KeyRIF)
+	if err != nil {
+		return nil, err
+	}
+
+	block := make([]byte, aes.BlockSize)
+	c.Decrypt(block, rif[ps3RifIndexOffset:ps3RifIndexOffset+aes.BlockSize])
+
+	index := int(binary.BigEndian.Uint32(block[12:]))
+	if index >= actDatKeyCount || len(actDat) < actDatKeysOffset+(index+1)*aes.BlockSize {
+		return nil, errors.New("invalid act.dat key index")
+	}
+
+	// the act.dat keys are encrypted with a key derived from the IDPS
+	actDatKey := make([]byte, aes.BlockSize)
+	if err := AESECBEncrypt(actDatKey, KeyActDat, idps); err != nil {
+		return nil, err
+	}
+
+	c, err = aes.NewCipher(actDatKey)
+	if err != nil {
+		return nil, err
+	}
+
+	actKey := make([]byte, aes.BlockSize)
+	offset := actDatKeysOffset + index*aes.BlockSize
+	c.Decrypt(actKey, actDat[offset:offset+aes.BlockSize])
+
+	c, err = aes.NewCipher(actKey)
+	if err != nil {
+		return nil, err
+	}
+
+	klicensee := make([]byte, aes.BlockSize)
+	c.Decrypt(klicensee, rif[ps3RifKeyOffset:ps3RifKeyOffset+aes.BlockSize])
+
+	return klicensee, nil
+}
+
+// EDATKlicensee returns the klicensee stored in a .rap or a .rif license,
+// actDat and idps are only used by the latter.
+func EDATKlicensee(license, actDat, idps []byte) ([]byte, error) {
+	switch len(license) {
+	case rapSize:
+		return RAPToKlicensee(license)
+	case ps3RifSize:
+		return RIFToKlicensee(license, actDat, idps)
+	default:
+		return nil, errors.New("unknown EDAT license format")
+	}
+}
@@ -0,0 +1,123 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"testing"
+)
+
+// testRAP is a .rap license, testRAPKlicensee its klicensee as calculated by
+// the rap_to_rif of RPCS3.
+var (
+	testRAP = []byte{
+		0x03, 0x14, 0x25, 0x36, 0x47, 0x58, 0x69, 0x7a,
+		0x8b, 0x9c, 0xad, 0xbe, 0xcf, 0xe0, 0xf1, 0x02,
+	}
+	testRAPKlicensee = []byte{
+		0xf0, 0xc6, 0x41, 0xf4, 0xe0, 0xb7, 0x67, 0x1b,
+		0x50, 0x8f, 0x0c, 0x92, 0x53, 0x9d, 0x2d, 0x8c,
+	}
+)
+
+func TestRAPToKlicensee(t *testing.T) {
+	tests := []struct {
+		rap, klicensee []byte
+	}{
+		{testRAP, testRAPKlicensee},
+		{
+			[]byte{
+				0xff, 0xee, 0xdd, 0xcc, 0xbb, 0xaa, 0x99, 0x88,
+				0x77, 0x66, 0x55, 0x44, 0x33, 0x22, 0x11, 0x00,
+			},
+			[]byte{
+				0x11, 0xd2, 0xaf, 0x96, 0x15, 0x84, 0xe0, 0x1c,
+				0x2f, 0xa8, 0x32, 0xdf, 0x40, 0x21, 0x10, 0x50,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		key, err := RAPToKlicensee(tt.rap)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(key, tt.klicensee) {
+			t.Errorf("RAP %x: got %x, want %x", tt.rap, key, tt.klicensee)
+		}
+	}
+
+	if _, err := RAPToKlicensee(testRAP[:15]); err == nil {
+		t.Fatal("accepted a short RAP")
+	}
+}
+
+// testPS3Rif returns a PS3 rif of the klicensee using the key index of the
+// act.dat, with the act.dat and the IDPS to decrypt it.
+func testPS3Rif(t *testing.T, klicensee []byte, index int) (rif, actDat, idps []byte) {
+	idps = randomData(aes.BlockSize)
+	actKey := []byte("act.dat key 0123")
+
+	encrypt := func(dst, src, key []byte) {
+		if err := AESECBEncrypt(dst, src, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	actDatKey := make([]byte, aes.BlockSize)
+	encrypt(actDatKey, KeyActDat, idps)
+
+	actDat = make([]byte, actDatKeysOffset+actDatKeyCount*aes.BlockSize)
+	encrypt(actDat[actDatKeysOffset+index*aes.BlockSize:], actKey, actDatKey)
+
+	rif = make([]byte, ps3RifSize)
+	copy(rif[0x10:], testContentID)
+
+	block := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint32(block[12:], uint32(index))
+	encrypt(rif[ps3RifIndexOffset:], block, KeyRIF)
+	encrypt(rif[ps3RifKeyOffset:], klicensee, actKey)
+
+	return rif, actDat, idps
+}
+
+func TestRIFToKlicensee(t *testing.T) {
+	rif, actDat, idps := testPS3Rif(t, testKlicensee, 5)
+
+	key, err := RIFToKlicensee(rif, actDat, idps)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(key, testKlicensee) {
+		t.Fatalf("got %x, want %x", key, testKlicensee)
+	}
+
+	// the act.dat of another console doesn't decrypt it
+	key, err = RIFToKlicensee(rif, actDat, randomData(32)[16:])
+	if err != nil || bytes.Equal(key, testKlicensee) {
+		t.Fatalf("decrypted the rif with another IDPS: %v", err)
+	}
+
+	if _, err := RIFToKlicensee(rif, actDat[:0x40], idps); err == nil {
+		t.Fatal("accepted a truncated act.dat")
+	}
+}
+
+func TestEDATKlicensee(t *testing.T) {
+	key, err := EDATKlicensee(testRAP, nil, nil)
+	if err != nil || !bytes.Equal(key, testRAPKlicensee) {
+		t.Fatalf("RAP license: got %x, %v", key, err)
+	}
+
+	rif, actDat, idps := testPS3Rif(t, testKlicensee, 0)
+	key, err = EDATKlicensee(rif, actDat, idps)
+	if err != nil || !bytes.Equal(key, testKlicensee) {
+		t.Fatalf("rif license: got %x, %v", key, err)
+	}
+
+	if _, err := EDATKlicensee(make([]byte, 20), nil, nil); err == nil {
+		t.Fatal("accepted an unknown license")
+	}
+}
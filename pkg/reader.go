@@ -9,25 +9,57 @@ import (
 	"hash"
 	"io"
 	"io/ioutil"
+	"log"
 	"os"
 )
 
 type PackageType int
 
 const (
-	PackageTypePSOne     PackageType = 1 << iota
+	PackageTypePSOne PackageType = 1 << iota
 	PackageTypePSP
 	PackageTypeVitaApp
 	PackageTypeVitaDLC
 	PackageTypeVitaPatch
 	PackageTypePSM
+	PackageTypePS3Game
+	PackageTypePS3PS2
+	PackageTypePS3Theme
+	PackageTypePS3Avatar
 )
 
+func (t PackageType) String() string {
+	switch t {
+	case PackageTypePSOne:
+		return "PSOne"
+	case PackageTypePSP:
+		return "PSP"
+	case PackageTypeVitaApp:
+		return "Vita App"
+	case PackageTypeVitaDLC:
+		return "Vita DLC"
+	case PackageTypeVitaPatch:
+		return "Vita Patch"
+	case PackageTypePSM:
+		return "PSM"
+	case PackageTypePS3Game:
+		return "PS3 Game"
+	case PackageTypePS3PS2:
+		return "PS3 PS2 Classic"
+	case PackageTypePS3Theme:
+		return "PS3 Theme"
+	case PackageTypePS3Avatar:
+		return "PS3 Avatar"
+	default:
+		return fmt.Sprintf("PackageType(%d)", int(t))
+	}
+}
+
 var fileHeader = [4]byte{0x7F, 0x50, 0x4B, 0x47}
 var extHeader = [4]byte{0x7F, 0x65, 0x78, 0x74}
 
 type indexData struct {
-	itemRecords []fileEntry
+	itemRecords []Entry
 	idx         int
 }
 
@@ -48,18 +80,38 @@ type Reader struct {
 	aesReader *ctrStream
 	// holds the calculated sha1sum
 	hasher hash.Hash
+	// number of bytes passed through the hasher
+	hashed byteCounter
 	// pkg header
 	FileHeader     FileHeader
+	rawHeader      []byte
 	extendedHeader ExtendedHeader
 	meta           Metadata
 	SfoEntries     map[string]string
+	SFO            *SFO
 	headBuffer     bytes.Buffer
 	tailBuffer     bytes.Buffer
 	rif            []byte
+	psmTitle       string
+	keys           KeyProvider
+	hashPolicy     HashPolicy
+	tailReport     *TailReport
+	logger         *log.Logger
 
 	// hashes, calculated and from file
 	FileHash       []byte
 	CalculatedHash []byte
+
+	// output format of the EBOOT.PBP of PSP packages
+	PSPOutput PSPOutputMode
+	// compression settings used by PSPOutputCSO and PSPOutputZSO
+	CSOOptions CSOOptions
+	// decrypt the EDAT and SDAT files of PS3 packages while unpacking
+	DecryptEDAT bool
+	// klicensee of the EDAT files, see EDATKlicensee. Not needed by SDAT files
+	EDATKey []byte
+	// preferred languages of the title used by GetTitle and the output names
+	TitleLanguages []Language
 }
 
 type ReadCloser struct {
@@ -67,29 +119,14 @@ type ReadCloser struct {
 	Reader
 }
 
+// OpenReader opens the named pkg file with an optional zRIF license.
 func OpenReader(name string, rif string) (*ReadCloser, error) {
-	f, err := os.Open(name)
-	if err != nil {
-		return nil, err
-	}
-
-	r := new(ReadCloser)
-	if err := r.init(f, rif); err != nil {
-		f.Close()
-		return nil, err
-	}
-
-	r.f = f
-	return r, nil
+	return OpenReaderWithOptions(name, &ReaderOptions{ZRIF: rif})
 }
 
+// NewReader creates a Reader from r with an optional zRIF license.
 func NewReader(r io.Reader, rif string) (*Reader, error) {
-	zr := new(Reader)
-	if err := zr.init(r, rif); err != nil {
-		return nil, err
-	}
-
-	return zr, nil
+	return NewReaderWithOptions(r, &ReaderOptions{ZRIF: rif})
 }
 
 func (rc *ReadCloser) Close() error {
@@ -121,6 +158,11 @@ func (pr *Reader) seekAhead(cur, offset int64) (pos int64, err error) {
 	return
 }
 
+// Entries returns the file index of the pkg.
+func (pr *Reader) Entries() []Entry {
+	return pr.index.itemRecords
+}
+
 func (pr *Reader) PackageType() PackageType {
 	return pr.pkgType
 }
@@ -172,9 +214,25 @@ func (pr *Reader) readMetadata(cur int64) (pos int64, err error) {
 	return
 }
 
-func (pr *Reader) setupDecryption() error {
+// detectPackageType maps the content type of the metadata to a PackageType.
+func (pr *Reader) detectPackageType() (PackageType, error) {
 	var pkgType PackageType
 
+	// the PSN packages of the PSP and the PSOne use the PS3 header type too,
+	// only the content types of the PS3 are installed as PS3 packages
+	if pr.FileHeader.IsPS3() && isPS3Content(pr.meta.ContentType) {
+		switch pr.meta.ContentType {
+		case ContentTypePS2:
+			return PackageTypePS3PS2, nil
+		case ContentTypeTheme:
+			return PackageTypePS3Theme, nil
+		case ContentTypeAvatar:
+			return PackageTypePS3Avatar, nil
+		default:
+			return PackageTypePS3Game, nil
+		}
+	}
+
 	switch pr.meta.ContentType {
 	case ContentTypePS1:
 		pkgType = PackageTypePSOne
@@ -191,113 +249,163 @@ func (pr *Reader) setupDecryption() error {
 	case ContentTypeVitaDLC:
 		pkgType = PackageTypeVitaDLC
 	case ContentTypePSM1:
+		fallthrough
 	case ContentTypePSM2:
 		pkgType = PackageTypePSM
 	default:
-		return fmt.Errorf("unsupported package type: %v", pkgType)
+		return 0, fmt.Errorf("unsupported package type: %v", pr.meta.ContentType)
 	}
 
-	if pkgType == PackageTypeVitaApp && pr.SfoEntries["CATEGORY"] == "gp" {
+	if pkgType == PackageTypeVitaApp && pr.AppParams().Category == CategoryPatch {
 		pkgType = PackageTypeVitaPatch
 	}
 
-	pr.pkgType = pkgType
+	return pkgType, nil
+}
 
-	var baseKey []byte
-	ctrKey := make([]byte, 16)
-
-	switch pr.extendedHeader.KeyType() {
-	case 1:
-		ctrKey = KeyPSP
-	case 2:
-		baseKey = VitaKey2
-	case 3:
-		baseKey = KeyVita3
-	case 4:
-		baseKey = KeyVita4
-	default:
-		return fmt.Errorf("unknown key type: %v", pr.extendedHeader.KeyType())
+func (pr *Reader) setupDecryption() error {
+	pkgType, err := pr.detectPackageType()
+	if err != nil {
+		return err
 	}
 
-	if pr.extendedHeader.KeyType() != 1 {
-		// encrypt the iv
-		err := AESECBEncrypt(ctrKey, pr.FileHeader.DataIV[:], baseKey)
+	pr.pkgType = pkgType
+
+	if pr.FileHeader.IsDebug() {
+		// debug packages use a keystream derived from the header digest
+		block := newDebugCipher(pr.FileHeader.Digest[:], pr.FileHeader.DataIV[:])
+		pr.aesReader = newCTRStream(pr.reader, block, pr.FileHeader.DataIV[:], 0)
+	} else {
+		keyType := pr.extendedHeader.KeyType()
+		if pr.FileHeader.IsPS3() && isPS3Content(pr.meta.ContentType) {
+			keyType = KeyTypePS3
+		}
+
+		ctrKey, err := pr.keyProvider().PackageKey(keyType, pr.FileHeader.DataIV[:])
 		if err != nil {
 			return err
 		}
-	}
 
-	reader, err := NewCTRReader(pr.reader, ctrKey, pr.FileHeader.DataIV[:], 0)
-	if err != nil {
-		return err
+		pr.aesReader, err = NewCTRReader(pr.reader, ctrKey, pr.FileHeader.DataIV[:], 0)
+		if err != nil {
+			return err
+		}
 	}
 
-	pr.aesReader = reader
-
 	// reader = raw + hash + head + aes
-	pr.reader = reader
+	pr.reader = pr.aesReader
 
 	return nil
 }
 
-func (pr *Reader) init(r io.Reader, rif string) error {
-	pr.hasher = sha1.New()
-	// combine the file reader with the hash calculator
-	hashReader := io.TeeReader(r, pr.hasher)
-	// combine the file reader (and hash calculator) with the head.bin buffer
-	headHashReader := io.TeeReader(hashReader, &pr.headBuffer)
+// keyProvider returns the KeyProvider of the reader, DefaultKeys if unset.
+func (pr *Reader) keyProvider() KeyProvider {
+	if pr.keys == nil {
+		return DefaultKeys
+	}
 
-	// reader = raw + hash + head
-	pr.reader = headHashReader
+	return pr.keys
+}
 
-	// read the pkg header
-	err := binary.Read(pr.reader, binary.BigEndian, &pr.FileHeader)
+// readHeaders reads and validates the pkg header and the extended header.
+func (pr *Reader) readHeaders() (cur int64, err error) {
+	// read the pkg header, keeping a copy of the raw bytes for VerifyHeader
+	pr.rawHeader = make([]byte, binary.Size(pr.FileHeader))
+	_, err = io.ReadFull(pr.reader, pr.rawHeader)
 	if err != nil {
-		return err
+		return
+	}
+
+	err = binary.Read(bytes.NewReader(pr.rawHeader), binary.BigEndian, &pr.FileHeader)
+	if err != nil {
+		return
 	}
 
-	cur := int64(binary.Size(pr.FileHeader))
+	cur = int64(binary.Size(pr.FileHeader))
 
 	if !bytes.Equal(pr.FileHeader.Magic[:], fileHeader[:]) {
-		return errors.New("invalid PKG file")
+		err = errors.New("invalid PKG file")
+		return
+	}
+
+	if pr.FileHeader.ItemCount == 0 {
+		err = errors.New("PKG has no item entries")
+		return
 	}
 
 	// check if the header size can hold both pkg headers
 	if pr.FileHeader.HeaderSize <= int32(binary.Size(pr.FileHeader)) {
-		return errors.New("unsupported PKG type (no extended header)")
-	}
+		if !pr.FileHeader.IsPS3() {
+			err = errors.New("unsupported PKG type (no extended header)")
+		}
 
-	if pr.FileHeader.ItemCount == 0 {
-		return errors.New("PKG has no item entries")
+		// PS3 packages can come without extended header
+		return
 	}
 
 	// read the extender header
 	err = binary.Read(pr.reader, binary.BigEndian, &pr.extendedHeader)
 	if err != nil {
-		return err
+		return
 	}
 
 	cur += int64(binary.Size(pr.extendedHeader))
 
 	if !bytes.Equal(pr.extendedHeader.Magic[:], extHeader[:]) {
-		return errors.New("invalid PKG extended header")
+		err = errors.New("invalid PKG extended header")
+		return
 	}
 
-	pr.rawReader = r
+	return
+}
 
-	cur, err = pr.readMetadata(cur)
+// readInfo reads the metadata records and the embedded SFO, if any.
+func (pr *Reader) readInfo(cur int64) (pos int64, err error) {
+	pos, err = pr.readMetadata(cur)
 	if err != nil {
-		return err
+		return
 	}
 
 	if pr.meta.SfoOffset > 0 && pr.meta.SfoSize > 0 {
-		cur, _ = pr.seekAhead(cur, int64(pr.meta.SfoOffset))
+		pos, _ = pr.seekAhead(pos, int64(pr.meta.SfoOffset))
 		n, err := pr.readSFO(pr.reader)
 		if err != nil {
-			return err
+			return pos, err
 		}
 
-		cur += n
+		pos += n
+	}
+
+	return
+}
+
+func (pr *Reader) init(r io.Reader, opts *ReaderOptions) error {
+	r = pr.applyOptions(r, opts)
+
+	var hashWriter io.Writer = &pr.hashed
+	if pr.hashPolicy != HashSkip {
+		pr.hasher = sha1.New()
+		hashWriter = io.MultiWriter(pr.hasher, &pr.hashed)
+	}
+
+	// combine the file reader with the hash calculator
+	hashReader := io.TeeReader(r, hashWriter)
+	// combine the file reader (and hash calculator) with the head.bin buffer
+	headHashReader := io.TeeReader(hashReader, &pr.headBuffer)
+
+	// reader = raw + hash + head
+	pr.reader = headHashReader
+
+	cur, err := pr.readHeaders()
+	if err != nil {
+		return err
+	}
+
+	pr.rawReader = r
+
+	cur, err = pr.readInfo(cur)
+	if err != nil {
+		return err
 	}
 
 	// advance to the first encrypted block
@@ -308,17 +416,24 @@ func (pr *Reader) init(r io.Reader, rif string) error {
 		return err
 	}
 
-	if len(rif) > 0 && pr.PackageType() != PackageTypePSOne && pr.PackageType() != PackageTypePSP {
-		pr.rif, err = DecodeLicense(rif, pr.PackageType())
+	pr.logf("%s: %s package, key type %d", pr.FileHeader.GetContentID(), pr.PackageType(), pr.extendedHeader.KeyType())
+
+	// PS3 packages are licensed per file, see the EDAT support
+	if pr.PackageType() != PackageTypePSOne && pr.PackageType() != PackageTypePSP && !pr.FileHeader.IsPS3() {
+		pr.rif, err = pr.license(opts)
 		if err != nil {
 			return err
 		}
+	}
 
-		rifid := pr.rifContentID()
-		cid := pr.FileHeader.GetContentID()
+	if len(pr.rif) > 0 {
+		lic, err := ParseLicense(pr.rif)
+		if err != nil {
+			return err
+		}
 
-		if rifid != cid {
-			return fmt.Errorf("zRIF content ID '%s' doesn't match pkg '%s'", rifid, cid)
+		if !lic.MatchesPackage(pr) {
+			return fmt.Errorf("license content ID '%s' doesn't match pkg '%s'", lic.ContentID, pr.FileHeader.GetContentID())
 		}
 	}
 
@@ -328,6 +443,7 @@ func (pr *Reader) init(r io.Reader, rif string) error {
 	}
 
 	pr.index = indexData{itemRecords: entries, idx: 0}
+	pr.logf("%s: %d entries", pr.FileHeader.GetContentID(), len(entries))
 
 	// drop the head.bin reader from the chain
 	pr.aesReader.SetRawReader(hashReader)
@@ -342,15 +458,25 @@ func (pr *Reader) readTail() error {
 	tailOffset := pr.FileHeader.DataOffset + pr.FileHeader.DataSize
 	tailSize := pr.FileHeader.TotalSize - tailOffset
 
-	_, err := io.CopyN(ioutil.Discard, tailHashReader, tailSize-0x20)
+	// the tail signature covers everything before it
+	var contentsHash []byte
+	if pr.hasher != nil {
+		contentsHash = pr.hasher.Sum(nil)
+	}
+
+	tailStart := pr.tailBuffer.Len()
+
+	_, err := io.CopyN(ioutil.Discard, tailHashReader, tailSize-fileHashSize)
 	if err != nil {
 		return err
 	}
 
-	pr.CalculatedHash = pr.hasher.Sum(nil)
+	if pr.hasher != nil {
+		pr.CalculatedHash = pr.hasher.Sum(nil)
+	}
 
 	tailHashReader = io.TeeReader(pr.rawReader, &pr.tailBuffer)
-	fileHash := make([]byte, 0x20)
+	fileHash := make([]byte, fileHashSize)
 
 	_, err = io.ReadFull(tailHashReader, fileHash)
 	if err != nil {
@@ -358,6 +484,15 @@ func (pr *Reader) readTail() error {
 	}
 
 	pr.FileHash = fileHash[0:20]
+	pr.checkTail(pr.tailBuffer.Bytes()[tailStart:], contentsHash)
+
+	if pr.hashPolicy != HashSkip && !pr.Valid() {
+		pr.logf("%s: PKG SHA1 check failed", pr.FileHeader.GetContentID())
+
+		if pr.hashPolicy == HashRequire {
+			return ErrHashMismatch
+		}
+	}
 
 	return nil
 }
@@ -370,23 +505,21 @@ func (pr *Reader) TailWriter(w io.Writer) (int64, error) {
 	return pr.tailBuffer.WriteTo(w)
 }
 
-func (pr *Reader) rifContentID() string {
-	var offset int
-	if pr.PackageType() == PackageTypePSM {
-		offset = 0x50
-	} else {
-		offset = 0x10
+func (pr *Reader) GetTitle() string {
+	if len(pr.TitleLanguages) > 0 && pr.SFO != nil {
+		return pr.SFO.TitleFor(pr.TitleLanguages...)
 	}
 
-	return string(pr.rif[offset: offset+36])
-}
-
-func (pr *Reader) GetTitle() string {
 	title, exists := pr.SfoEntries["TITLE"]
 	if !exists {
 		title = pr.SfoEntries["STITLE"]
 	}
 
+	if title == "" {
+		// PSM packages have no SFO, the title is read from app.info
+		title = pr.psmTitle
+	}
+
 	return title
 }
 
@@ -395,7 +528,7 @@ func (pr *Reader) GetTitleID() string {
 }
 
 func (pr *Reader) GetRegion() string {
-	if pr.extendedHeader.KeyType() != 1 {
+	if pr.extendedHeader.KeyType() != 1 && !pr.FileHeader.IsPS3() {
 		// Vita codes, 4th letter of TITLE_ID
 		id := pr.FileHeader.ContentID[10]
 
@@ -415,7 +548,7 @@ func (pr *Reader) GetRegion() string {
 			return "ASIA"
 		}
 	} else {
-		// PSP codes, 3rd letter of TITLE_ID
+		// PSP and PS3 codes, 3rd letter of TITLE_ID
 		id := pr.FileHeader.ContentID[9]
 		if id == 'U' {
 			return "USA"
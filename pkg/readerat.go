@@ -0,0 +1,125 @@
+package pkg
+
+import (
+	"crypto/cipher"
+	"errors"
+	"io"
+)
+
+// A ReaderAt provides random access to the entries of a pkg archive. The
+// headers and the file index are parsed once, then every entry can be opened
+// independently by computing its AES-CTR counter from the data offset.
+//
+// ReaderAt implements fs.FS, fs.ReadDirFS and fs.StatFS.
+type ReaderAt struct {
+	r     io.ReaderAt
+	size  int64
+	names map[string]int
+	nodes map[string]*fsNode
+	Reader
+}
+
+// An entryReader decrypts the data of a single entry on demand.
+type entryReader struct {
+	r      io.ReaderAt
+	block  cipher.Block
+	iv     []byte
+	base   int64
+	offset int64
+	size   int64
+}
+
+func OpenReaderAt(r io.ReaderAt, size int64, rif string) (*ReaderAt, error) {
+	return OpenReaderAtWithOptions(r, size, &ReaderOptions{ZRIF: rif})
+}
+
+// OpenReaderAtWithOptions is like OpenReaderAt, see NewReaderWithOptions for
+// the options.
+func OpenReaderAtWithOptions(r io.ReaderAt, size int64, opts *ReaderOptions) (*ReaderAt, error) {
+	ra := &ReaderAt{r: r, size: size}
+	if err := ra.init(io.NewSectionReader(r, 0, size), opts); err != nil {
+		return nil, err
+	}
+
+	if ra.FileHeader.TotalSize > size {
+		return nil, errors.New("pkg: truncated pkg file")
+	}
+
+	ra.names = make(map[string]int, len(ra.index.itemRecords))
+	for idx, entry := range ra.index.itemRecords {
+		ra.names[entry.name] = idx
+	}
+
+	ra.buildTree()
+
+	return ra, nil
+}
+
+// Lookup returns the entry with the given name.
+func (ra *ReaderAt) Lookup(name string) (*Entry, bool) {
+	idx, ok := ra.names[name]
+	if !ok {
+		return nil, false
+	}
+
+	return &ra.index.itemRecords[idx], true
+}
+
+// OpenEntry returns a reader with the decrypted contents of the entry.
+func (ra *ReaderAt) OpenEntry(entry *Entry) (*io.SectionReader, error) {
+	if entry.IsDirectory() {
+		return nil, errors.New("pkg: entry is a directory")
+	}
+
+	if entry.size < 0 || entry.offset+entry.size > ra.FileHeader.DataSize {
+		return nil, errors.New("pkg: invalid pkg header")
+	}
+
+	er := &entryReader{
+		r:      ra.r,
+		block:  entry.key,
+		iv:     ra.FileHeader.DataIV[:],
+		base:   ra.FileHeader.DataOffset,
+		offset: entry.offset,
+		size:   entry.size,
+	}
+
+	return io.NewSectionReader(er, 0, entry.size), nil
+}
+
+func (er *entryReader) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("pkg: negative offset")
+	}
+
+	if off >= er.size {
+		return 0, io.EOF
+	}
+
+	wanted := len(b)
+	if remaining := er.size - off; int64(wanted) > remaining {
+		b = b[0:remaining]
+	}
+
+	pos := er.offset + off
+	n, err := er.r.ReadAt(b, er.base+pos)
+
+	stream := NewCTR(er.block, er.iv, pos/16)
+	if skew := pos % 16; skew > 0 {
+		// drop the keystream bytes before the requested position
+		discard := make([]byte, skew)
+		stream.XORKeyStream(discard, discard)
+	}
+
+	stream.XORKeyStream(b[:n], b[:n])
+
+	if err == io.EOF && n == len(b) {
+		err = nil
+	}
+
+	if err == nil && n < wanted {
+		err = io.EOF
+	}
+
+	return n, err
+}
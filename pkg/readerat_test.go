@@ -0,0 +1,128 @@
+package pkg
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// testdata/vita.pkg is a synthetic Vita application generated offline, not
+// a retail package. It is encrypted with the type 2 key and holds an eboot.bin
+// of testEbootSize bytes from randomData, an icon and a PARAM.SFO.
+const (
+	testContentID   = "EP0000-PCSB00000_00-0000000000000000"
+	testEbootSize   = 70001
+	testIconData    = "icon"
+	testVitaPkg     = "vita.pkg"
+	testVitaTitle   = "Test Game"
+	testVitaEntries = 4
+)
+
+func randomData(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	return data
+}
+
+func readTestdata(t *testing.T, name string) []byte {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func openTestPackage(t *testing.T, data []byte) *ReaderAt {
+	ra, err := OpenReaderAt(bytes.NewReader(data), int64(len(data)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ra
+}
+
+func TestOpenEntry(t *testing.T) {
+	ra := openTestPackage(t, readTestdata(t, testVitaPkg))
+	eboot := randomData(testEbootSize)
+
+	if n := len(ra.Entries()); n != testVitaEntries {
+		t.Fatalf("got %d entries, want %d", n, testVitaEntries)
+	}
+
+	entry, ok := ra.Lookup("eboot.bin")
+	if !ok {
+		t.Fatal("eboot.bin not found")
+	}
+
+	sr, err := ra.OpenEntry(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sr.Size() != testEbootSize {
+		t.Fatalf("got size %d, want %d", sr.Size(), testEbootSize)
+	}
+
+	// reads starting inside the AES blocks and crossing them
+	tests := []struct {
+		off, size int
+	}{
+		{0, 16},
+		{1, 1},
+		{15, 2},
+		{17, 100},
+		{4095, 4098},
+		{testEbootSize - 7, 7},
+		{0, testEbootSize},
+	}
+
+	for _, tt := range tests {
+		b := make([]byte, tt.size)
+		n, err := sr.ReadAt(b, int64(tt.off))
+		if err != nil || n != tt.size {
+			t.Fatalf("ReadAt(%d, %d) = %d, %v", tt.off, tt.size, n, err)
+		}
+
+		if !bytes.Equal(b, eboot[tt.off:tt.off+tt.size]) {
+			t.Fatalf("ReadAt(%d, %d) returned the wrong data", tt.off, tt.size)
+		}
+	}
+
+	b := make([]byte, 20)
+	if n, err := sr.ReadAt(b, testEbootSize-10); n != 10 || err != io.EOF {
+		t.Fatalf("ReadAt past the end = %d, %v, want 10, EOF", n, err)
+	}
+
+	if n, err := sr.ReadAt(b, testEbootSize); n != 0 || err != io.EOF {
+		t.Fatalf("ReadAt at the end = %d, %v, want 0, EOF", n, err)
+	}
+
+	// the entries can be read in any order and more than once
+	icon, _ := ra.Lookup("sce_sys/icon0.png")
+	sr, err = ra.OpenEntry(icon)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadAll(sr)
+	if err != nil || string(data) != testIconData {
+		t.Fatalf("got icon %q, %v", data, err)
+	}
+
+	dir, _ := ra.Lookup("sce_sys")
+	if _, err := ra.OpenEntry(dir); err == nil {
+		t.Fatal("opened a directory")
+	}
+}
+
+func TestOpenReaderAtTruncated(t *testing.T) {
+	data := readTestdata(t, testVitaPkg)
+
+	if _, err := OpenReaderAt(bytes.NewReader(data), int64(len(data)-1), ""); err == nil {
+		t.Fatal("opened a truncated package")
+	}
+}
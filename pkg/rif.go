@@ -0,0 +1,197 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// LicenseFormat is the layout of a RIF license.
+type LicenseFormat int
+
+const (
+	// LicenseVita is the 512 bytes license of the Vita games and DLCs
+	LicenseVita LicenseFormat = iota
+	// LicensePSM is the 1024 bytes license of the PSM games
+	LicensePSM
+)
+
+func (f LicenseFormat) String() string {
+	switch f {
+	case LicenseVita:
+		return "Vita"
+	case LicensePSM:
+		return "PSM"
+	default:
+		return "Unknown"
+	}
+}
+
+const (
+	vitaRifSize = 512
+	psmRifSize  = 1024
+	// account ID of the licenses created by NoNpDrm
+	fakeAccountID = 0x0123456789abcdef
+)
+
+// vitaRif is the layout of a Vita license, the SceNpDrmLicense structure.
+type vitaRif struct {
+	Version        uint16
+	VersionFlags   uint16
+	Type           uint16
+	Flags          uint16
+	AccountID      uint64 // little endian, see ParseLicense
+	ContentID      [0x30]byte
+	KeyTable       [0x10]byte
+	Key            [0x10]byte
+	StartTime      uint64
+	ExpirationTime uint64
+	Signature      [0x28]byte
+	Flags2         uint64
+	Key2           [0x10]byte
+	Unknown        [0x10]byte
+	OpenPSID       [0x10]byte
+	Unknown2       [0x2c]byte
+	SKUFlag        uint32
+	RSASignature   [0x100]byte
+}
+
+// psmRif is the layout of a PSM license, the ScePsmDrmLicense structure.
+type psmRif struct {
+	Magic          [8]byte
+	Unknown        [8]byte
+	AccountID      uint64
+	Unknown1       [8]byte
+	StartTime      uint64
+	ExpirationTime uint64
+	ActDigest      [0x20]byte
+	ContentID      [0x30]byte
+	Unknown2       [0xa0]byte
+	Key            [0x10]byte
+	Signature      [0x1d0]byte
+	RSASignature   [0x100]byte
+}
+
+// A License is a parsed Vita or PSM RIF license, like a work.bin file or a
+// decoded zRIF.
+type License struct {
+	Format LicenseFormat
+	// Version, VersionFlags, Type and Flags are only used by the Vita
+	// licenses
+	Version      uint16
+	VersionFlags uint16
+	Type         uint16
+	Flags        uint16
+	// AccountID of the PSN account that owns the license
+	AccountID uint64
+	ContentID string
+	// Key is the encrypted klicensee
+	Key []byte
+	// StartTime and ExpirationTime are zero if the license has no limits
+	StartTime      time.Time
+	ExpirationTime time.Time
+	// Signature is the ECDSA signature of the Vita licenses, or the signed
+	// area of the PSM licenses
+	Signature    []byte
+	RSASignature []byte
+	// Raw holds the license data
+	Raw []byte
+}
+
+// ParseLicense parses a Vita or a PSM license, the format is chosen by the
+// size of the data.
+func ParseLicense(data []byte) (*License, error) {
+	lic := &License{Raw: dup(data)}
+
+	switch len(data) {
+	case vitaRifSize:
+		var rif vitaRif
+		if err := binary.Read(bytes.NewReader(data), binary.BigEndian, &rif); err != nil {
+			return nil, err
+		}
+
+		lic.Format = LicenseVita
+		lic.Version = rif.Version
+		lic.VersionFlags = rif.VersionFlags
+		lic.Type = rif.Type
+		lic.Flags = rif.Flags
+		// the account ID is stored little endian, the NoNpDrm licenses
+		// start with 00 01 00 01 00 01 00 02 ef cd ab 89 67 45 23 01
+		lic.AccountID = binary.LittleEndian.Uint64(data[0x8:])
+		lic.ContentID = cString(rif.ContentID[:])
+		lic.Key = dup(rif.Key[:])
+		lic.StartTime = licenseTime(rif.StartTime)
+		lic.ExpirationTime = licenseTime(rif.ExpirationTime)
+		lic.Signature = dup(rif.Signature[:])
+		lic.RSASignature = dup(rif.RSASignature[:])
+	case psmRifSize:
+		var rif psmRif
+		if err := binary.Read(bytes.NewReader(data), binary.BigEndian, &rif); err != nil {
+			return nil, err
+		}
+
+		lic.Format = LicensePSM
+		lic.AccountID = rif.AccountID
+		lic.ContentID = cString(rif.ContentID[:])
+		lic.Key = dup(rif.Key[:])
+		lic.StartTime = licenseTime(rif.StartTime)
+		lic.ExpirationTime = licenseTime(rif.ExpirationTime)
+		lic.Signature = dup(rif.Signature[:])
+		lic.RSASignature = dup(rif.RSASignature[:])
+	default:
+		return nil, errors.New("invalid license length")
+	}
+
+	return lic, nil
+}
+
+// IsAccountBound reports whether the license belongs to a PSN account. The
+// licenses created by NoNpDrm and NoPsmDrm use an empty or a fake account.
+func (l *License) IsAccountBound() bool {
+	return l.AccountID != 0 && l.AccountID != fakeAccountID
+}
+
+// IsExpired reports whether the license has an expiration time before t.
+func (l *License) IsExpired(t time.Time) bool {
+	return !l.ExpirationTime.IsZero() && l.ExpirationTime.Before(t)
+}
+
+// Matches reports whether the license is for the package with the given
+// content ID.
+func (l *License) Matches(contentID string) bool {
+	return l.ContentID == contentID
+}
+
+// MatchesPackage reports whether the license is for the package.
+func (l *License) MatchesPackage(pr *Reader) bool {
+	return l.Matches(pr.FileHeader.GetContentID())
+}
+
+// License returns the parsed license of the package, or nil if the package
+// was opened without one.
+func (pr *Reader) License() (*License, error) {
+	if len(pr.rif) == 0 {
+		return nil, nil
+	}
+
+	return ParseLicense(pr.rif)
+}
+
+// licenseTime converts the timestamps of the licenses, in milliseconds since
+// the Unix epoch.
+func licenseTime(ms uint64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(int64(ms/1000), int64(ms%1000)*int64(time.Millisecond)).UTC()
+}
+
+func cString(b []byte) string {
+	if n := bytes.IndexByte(b, 0); n >= 0 {
+		b = b[:n]
+	}
+
+	return string(b)
+}
@@ -0,0 +1,177 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPSMRif returns a PSM license for the content ID, with the key at 0x120
+// and the time limits in milliseconds.
+func testPSMRif(contentID string, accountID, start, expiration uint64) []byte {
+	rif := make([]byte, psmRifSize)
+	copy(rif, "NPSMRIF\x00")
+	binary.BigEndian.PutUint64(rif[0x10:], accountID)
+	binary.BigEndian.PutUint64(rif[0x20:], start)
+	binary.BigEndian.PutUint64(rif[0x28:], expiration)
+	copy(rif[0x50:], contentID)
+	copy(rif[0x120:], "psm klicensee!!!")
+
+	return rif
+}
+
+func TestParseLicenseVita(t *testing.T) {
+	// the NoNpDrm licenses use a fake account and have no time limits
+	rif := testVitaRif()
+	binary.BigEndian.PutUint16(rif, 1)
+	binary.BigEndian.PutUint16(rif[4:], 1)
+	binary.LittleEndian.PutUint64(rif[0x8:], fakeAccountID)
+	copy(rif[0x50:], "vita klicensee!!")
+
+	lic, err := ParseLicense(rif)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lic.Format != LicenseVita || lic.Version != 1 || lic.Type != 1 {
+		t.Errorf("got %s license version %d, type %d", lic.Format, lic.Version, lic.Type)
+	}
+
+	if lic.ContentID != testContentID || string(lic.Key) != "vita klicensee!!" {
+		t.Errorf("got content ID %q, key %q", lic.ContentID, lic.Key)
+	}
+
+	if lic.IsAccountBound() || lic.IsExpired(time.Now()) || !lic.StartTime.IsZero() {
+		t.Error("the fake license has limits")
+	}
+
+	if !bytes.Equal(lic.Raw, rif) || len(lic.RSASignature) != 0x100 {
+		t.Error("wrong raw data")
+	}
+
+	binary.LittleEndian.PutUint64(rif[0x8:], 0x1122334455667788)
+	if lic, _ := ParseLicense(rif); !lic.IsAccountBound() || lic.AccountID != 0x1122334455667788 {
+		t.Error("the license isn't bound to the account")
+	}
+}
+
+func TestDecodeNoNpDrmLicense(t *testing.T) {
+	// the header of the fake licenses made by NoNpDrm: version 1, type 1,
+	// flags 2 and the fake account ID
+	rif := testVitaRif()
+	copy(rif, []byte{0x00, 0x01, 0x00, 0x01, 0x00, 0x01, 0x00, 0x02, 0xef, 0xcd, 0xab, 0x89, 0x67, 0x45, 0x23, 0x01})
+
+	zrif, err := EncodeLicense(rif)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := DecodeLicense(zrif, PackageTypeVitaApp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lic, err := ParseLicense(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lic.IsAccountBound() || lic.AccountID != fakeAccountID {
+		t.Errorf("the fake license is bound to account 0x%016x", lic.AccountID)
+	}
+
+	if lic.Version != 1 || lic.Type != 1 || lic.Flags != 2 || lic.ContentID != testContentID {
+		t.Errorf("got version %d, type %d, flags %d, content ID %q", lic.Version, lic.Type, lic.Flags, lic.ContentID)
+	}
+}
+
+func TestParseLicensePSM(t *testing.T) {
+	const contentID = "JP0000-NPOA00000_00-0000000000000000"
+
+	// 2017-07-14 02:40:00.123 UTC to 2018-07-14 02:40:00 UTC
+	rif := testPSMRif(contentID, 0x1122334455667788, 1500000000123, 1531536000000)
+
+	lic, err := ParseLicense(rif)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lic.Format != LicensePSM || lic.ContentID != contentID || string(lic.Key) != "psm klicensee!!!" {
+		t.Errorf("got %s license %q, key %q", lic.Format, lic.ContentID, lic.Key)
+	}
+
+	if !lic.IsAccountBound() {
+		t.Error("the license isn't bound to the account")
+	}
+
+	start := time.Date(2017, 7, 14, 2, 40, 0, 123*int(time.Millisecond), time.UTC)
+	expiration := time.Date(2018, 7, 14, 2, 40, 0, 0, time.UTC)
+	if !lic.StartTime.Equal(start) || !lic.ExpirationTime.Equal(expiration) {
+		t.Errorf("got times %v, %v", lic.StartTime, lic.ExpirationTime)
+	}
+
+	if lic.IsExpired(expiration.Add(-time.Second)) || !lic.IsExpired(expiration.Add(time.Second)) {
+		t.Error("wrong expiration check")
+	}
+
+	if lic.Matches(testContentID) || !lic.Matches(contentID) {
+		t.Error("wrong content ID check")
+	}
+
+	for _, size := range []int{0, vitaRifSize - 1, psmRifSize + 1} {
+		if _, err := ParseLicense(make([]byte, size)); err == nil {
+			t.Errorf("parsed a license of %d bytes", size)
+		}
+	}
+}
+
+func TestLicenseMatchesPackage(t *testing.T) {
+	data := readTestdata(t, testVitaPkg)
+
+	r, err := NewReaderWithOptions(bytes.NewReader(data), &ReaderOptions{RIF: testVitaRif()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lic, err := r.License()
+	if err != nil || !lic.MatchesPackage(r) {
+		t.Fatalf("the license doesn't match the package: %v", err)
+	}
+
+	// the license of another package is refused
+	rif := testVitaRif()
+	copy(rif[0x10:], "EP0000-PCSB00001")
+	_, err = NewReaderWithOptions(bytes.NewReader(data), &ReaderOptions{RIF: rif})
+	if err == nil || !strings.Contains(err.Error(), "doesn't match") {
+		t.Fatalf("opened the package with the license of another one: %v", err)
+	}
+
+	r, err = NewReader(bytes.NewReader(data), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lic, err := r.License(); lic != nil || err != nil {
+		t.Fatalf("got license %v, %v without one", lic, err)
+	}
+}
+
+func TestDecodeLicense(t *testing.T) {
+	rif := testPSMRif("JP0000-NPOA00000_00-0000000000000000", 0, 0, 0)
+
+	zrif, err := EncodeLicense(rif)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := DecodeLicense(zrif, PackageTypePSM)
+	if err != nil || !bytes.Equal(data, rif) {
+		t.Fatalf("the decoded license doesn't match: %v", err)
+	}
+
+	if _, err := DecodeLicense(zrif, PackageTypeVitaApp); err == nil {
+		t.Fatal("decoded a PSM license as a Vita one")
+	}
+}
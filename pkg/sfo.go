@@ -4,18 +4,46 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"strconv"
 )
 
 var sfoMagic = [4]byte{0x00, 0x50, 0x53, 0x46}
 
+// SFOFormat is the type of the value of a SFO parameter.
+type SFOFormat uint16
+
+const (
+	// SFOFormatUTF8Special is a string without the NUL terminator
+	SFOFormatUTF8Special SFOFormat = 0x0004
+	// SFOFormatUTF8 is a NUL terminated string
+	SFOFormatUTF8 SFOFormat = 0x0204
+	// SFOFormatInteger is a 32 bit little endian integer
+	SFOFormatInteger SFOFormat = 0x0404
+)
+
 const (
-	utf8Special uint16 = 0x0004
-	utf8        uint16 = 0x0204
-	integer     uint16 = 0x0404
+	sfoVersion    = 0x0101
+	sfoHeaderSize = 0x14
+	sfoEntrySize  = 0x10
+	// the real files are a few KiB, the tables past this size are corrupt
+	sfoMaxSize = 0x100000
 )
 
+func (f SFOFormat) String() string {
+	switch f {
+	case SFOFormatUTF8Special:
+		return "utf8-special"
+	case SFOFormatUTF8:
+		return "utf8"
+	case SFOFormatInteger:
+		return "integer"
+	default:
+		return fmt.Sprintf("%#04x", uint16(f))
+	}
+}
+
 type sfoHeader struct {
 	Magic             [4]byte
 	Version           int32
@@ -32,7 +60,94 @@ type sfoIndexTableEntry struct {
 	DataOffset     uint32
 }
 
-func (pr *Reader) readSFO(r io.Reader) (n int64, err error) {
+// A SFOParam is a parameter of a SFO file.
+type SFOParam struct {
+	Key    string
+	Format SFOFormat
+	// MaxLength is the space reserved for the value in the data table
+	MaxLength uint32
+	// Value is the raw value, including the NUL terminator of SFOFormatUTF8
+	Value []byte
+}
+
+// String returns the value as a string, integers are formatted in decimal.
+func (p *SFOParam) String() string {
+	switch p.Format {
+	case SFOFormatInteger:
+		return strconv.FormatUint(uint64(p.Int()), 10)
+	case SFOFormatUTF8:
+		return string(bytes.TrimSuffix(p.Value, []byte{0}))
+	default:
+		return string(p.Value)
+	}
+}
+
+// Int returns the value of an integer parameter, or zero for strings.
+func (p *SFOParam) Int() uint32 {
+	if p.Format != SFOFormatInteger || len(p.Value) < 4 {
+		return 0
+	}
+
+	return binary.LittleEndian.Uint32(p.Value)
+}
+
+// SetString replaces the value of a string parameter.
+func (p *SFOParam) SetString(value string) error {
+	var data []byte
+
+	switch p.Format {
+	case SFOFormatUTF8:
+		data = append([]byte(value), 0)
+	case SFOFormatUTF8Special:
+		data = []byte(value)
+	default:
+		return fmt.Errorf("%s is not a string parameter", p.Key)
+	}
+
+	if uint32(len(data)) > p.MaxLength {
+		return fmt.Errorf("%s is longer than %d bytes", p.Key, p.MaxLength)
+	}
+
+	p.Value = data
+	return nil
+}
+
+// SetInt replaces the value of an integer parameter.
+func (p *SFOParam) SetInt(value uint32) error {
+	if p.Format != SFOFormatInteger {
+		return fmt.Errorf("%s is not an integer parameter", p.Key)
+	}
+
+	p.Value = make([]byte, 4)
+	binary.LittleEndian.PutUint32(p.Value, value)
+
+	return nil
+}
+
+// A SFO is a PARAM.SFO file. The parameters keep the order of the file, and
+// the layout and padding of the parsed file are kept as long as no
+// parameters are added, removed or resized so the file is written back byte
+// for byte.
+type SFO struct {
+	Version uint32
+	Params  []*SFOParam
+
+	// layout of the parsed file
+	index     []sfoIndexTableEntry
+	indexKeys []string
+	gap       []byte
+	keyTable  []byte
+	dataTable []byte
+}
+
+// NewSFO returns an empty SFO.
+func NewSFO() *SFO {
+	return &SFO{Version: sfoVersion}
+}
+
+// Parse reads a SFO file from r, returning the number of bytes read. Only
+// the SFO is read so r can continue with other data.
+func (s *SFO) Parse(r io.Reader) (n int64, err error) {
 	var header sfoHeader
 	err = binary.Read(r, binary.LittleEndian, &header)
 	if err != nil {
@@ -46,6 +161,13 @@ func (pr *Reader) readSFO(r io.Reader) (n int64, err error) {
 		return
 	}
 
+	indexEnd := sfoHeaderSize + int64(header.IndexTableEntries)*sfoEntrySize
+	if header.IndexTableEntries < 0 || int64(header.KeyTableOffset) < indexEnd ||
+		header.DataTableOffset < header.KeyTableOffset || header.DataTableOffset > sfoMaxSize {
+		err = errors.New("invalid SFO tables")
+		return
+	}
+
 	index := make([]sfoIndexTableEntry, header.IndexTableEntries)
 	err = binary.Read(r, binary.LittleEndian, &index)
 	if err != nil {
@@ -54,16 +176,38 @@ func (pr *Reader) readSFO(r io.Reader) (n int64, err error) {
 
 	n += int64(binary.Size(index))
 
+	gap := make([]byte, int64(header.KeyTableOffset)-indexEnd)
+	_, err = io.ReadFull(r, gap)
+	if err != nil {
+		return
+	}
+
+	n += int64(len(gap))
+
 	keys := make([]byte, header.DataTableOffset-header.KeyTableOffset)
 	_, err = io.ReadFull(r, keys)
 	if err != nil {
 		return
 	}
 
-	n += int64(binary.Size(keys))
+	n += int64(len(keys))
+
+	// the sizes come from the file, the sums are done in int64 so they can't
+	// wrap. ParamLength is within ParamMaxLength so the values sliced below
+	// stay inside the data table
+	var valuesSize int64
+	for _, entry := range index {
+		end := int64(entry.DataOffset) + int64(entry.ParamMaxLength)
+		if entry.ParamLength > entry.ParamMaxLength || int(entry.KeyOffset) >= len(keys) ||
+			end > sfoMaxSize {
+			err = errors.New("invalid SFO index entry")
+			return
+		}
 
-	last := index[len(index)-1]
-	valuesSize := last.DataOffset + last.ParamMaxLength
+		if end > valuesSize {
+			valuesSize = end
+		}
+	}
 
 	values := make([]byte, valuesSize)
 	_, err = io.ReadFull(r, values)
@@ -71,29 +215,230 @@ func (pr *Reader) readSFO(r io.Reader) (n int64, err error) {
 		return
 	}
 
-	n += int64(valuesSize)
+	n += valuesSize
 
-	pr.SfoEntries = map[string]string{}
+	s.Version = uint32(header.Version)
+	s.Params = make([]*SFOParam, len(index))
+	s.indexKeys = make([]string, len(index))
 
-	for _, entry := range index {
-		switch entry.ParamFormat {
-		case utf8Special:
-			n := bytes.IndexByte(keys[entry.KeyOffset:], 0)
-			key := string(keys[entry.KeyOffset : int(entry.KeyOffset)+n])
-			value := string(values[entry.DataOffset : entry.DataOffset+entry.ParamLength])
-			pr.SfoEntries[key] = value
-		case utf8:
-			n := bytes.IndexByte(keys[entry.KeyOffset:], 0)
-			key := string(keys[entry.KeyOffset : int(entry.KeyOffset)+n])
-			value := string(values[entry.DataOffset : entry.DataOffset+entry.ParamLength-1])
-			pr.SfoEntries[key] = value
-		case integer:
-			n := bytes.IndexByte(keys[entry.KeyOffset:], 0)
-			key := string(keys[entry.KeyOffset : int(entry.KeyOffset)+n])
-			value := binary.LittleEndian.Uint32(values[entry.DataOffset : entry.DataOffset+entry.ParamLength])
-			pr.SfoEntries[key] = strconv.Itoa(int(value))
+	for i, entry := range index {
+		start := int64(entry.DataOffset)
+		key := keys[entry.KeyOffset:]
+		if end := bytes.IndexByte(key, 0); end >= 0 {
+			key = key[:end]
+		}
+
+		s.Params[i] = &SFOParam{
+			Key:       string(key),
+			Format:    SFOFormat(entry.ParamFormat),
+			MaxLength: entry.ParamMaxLength,
+			Value:     dup(values[start : start+int64(entry.ParamLength)]),
 		}
+		s.indexKeys[i] = string(key)
 	}
 
+	s.index = index
+	s.gap = gap
+	s.keyTable = keys
+	s.dataTable = values
+
 	return
 }
+
+// Get returns the parameter with the given key, or nil if it doesn't exist.
+func (s *SFO) Get(key string) *SFOParam {
+	for _, p := range s.Params {
+		if p.Key == key {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// Add inserts a new parameter with an empty value, keeping the keys sorted
+// like the files made by the console. The max length of the strings is
+// rounded up to a multiple of 4, the integers always use 4 bytes.
+func (s *SFO) Add(key string, format SFOFormat, maxLength uint32) (*SFOParam, error) {
+	if s.Get(key) != nil {
+		return nil, fmt.Errorf("%s already exists", key)
+	}
+
+	p := &SFOParam{Key: key, Format: format}
+
+	switch format {
+	case SFOFormatInteger:
+		p.MaxLength = 4
+		p.Value = make([]byte, 4)
+	case SFOFormatUTF8:
+		p.MaxLength = align4(maxLength)
+		p.Value = []byte{0}
+	case SFOFormatUTF8Special:
+		p.MaxLength = align4(maxLength)
+	default:
+		return nil, fmt.Errorf("unknown SFO format: %v", format)
+	}
+
+	if p.MaxLength == 0 {
+		return nil, fmt.Errorf("invalid max length for %s", key)
+	}
+
+	// before the first greater key
+	idx := len(s.Params)
+	for i, param := range s.Params {
+		if param.Key > key {
+			idx = i
+			break
+		}
+	}
+
+	s.Params = append(s.Params, nil)
+	copy(s.Params[idx+1:], s.Params[idx:])
+	s.Params[idx] = p
+
+	return p, nil
+}
+
+// Remove deletes the parameter with the given key, reporting whether it
+// existed.
+func (s *SFO) Remove(key string) bool {
+	for i, p := range s.Params {
+		if p.Key == key {
+			s.Params = append(s.Params[:i], s.Params[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Map returns the values of the parameters formatted as strings.
+func (s *SFO) Map() map[string]string {
+	entries := make(map[string]string, len(s.Params))
+	for _, p := range s.Params {
+		entries[p.Key] = p.String()
+	}
+
+	return entries
+}
+
+// sameLayout reports whether the parameters still fit in the parsed layout.
+func (s *SFO) sameLayout() bool {
+	if s.index == nil || len(s.Params) != len(s.index) {
+		return false
+	}
+
+	for i, p := range s.Params {
+		if p.Key != s.indexKeys[i] || p.MaxLength != s.index[i].ParamMaxLength ||
+			uint32(len(p.Value)) > p.MaxLength {
+			return false
+		}
+	}
+
+	return true
+}
+
+// layout builds the tables of a new SFO file, with the keys and values in
+// the order of the parameters.
+func (s *SFO) layout() (index []sfoIndexTableEntry, keys, values []byte, err error) {
+	index = make([]sfoIndexTableEntry, len(s.Params))
+
+	for i, p := range s.Params {
+		if uint32(len(p.Value)) > p.MaxLength {
+			err = fmt.Errorf("%s is longer than %d bytes", p.Key, p.MaxLength)
+			return
+		}
+
+		if len(keys) > 0xffff {
+			err = errors.New("SFO key table too large")
+			return
+		}
+
+		index[i] = sfoIndexTableEntry{
+			KeyOffset:      uint16(len(keys)),
+			ParamFormat:    uint16(p.Format),
+			ParamLength:    uint32(len(p.Value)),
+			ParamMaxLength: p.MaxLength,
+			DataOffset:     uint32(len(values)),
+		}
+
+		keys = append(keys, p.Key...)
+		keys = append(keys, 0)
+
+		value := make([]byte, p.MaxLength)
+		copy(value, p.Value)
+		values = append(values, value...)
+	}
+
+	keys = append(keys, make([]byte, int(align4(uint32(len(keys))))-len(keys))...)
+
+	return
+}
+
+// WriteTo writes the SFO file to w.
+func (s *SFO) WriteTo(w io.Writer) (int64, error) {
+	var index []sfoIndexTableEntry
+	var gap, keys, values []byte
+
+	if s.sameLayout() {
+		index = make([]sfoIndexTableEntry, len(s.index))
+		copy(index, s.index)
+		gap = s.gap
+		keys = s.keyTable
+		values = dup(s.dataTable)
+
+		for i, p := range s.Params {
+			entry := &index[i]
+			data := values[entry.DataOffset : entry.DataOffset+entry.ParamMaxLength]
+
+			// clear the rest of the old value, but keep the padding
+			if n := copy(data, p.Value); uint32(n) < entry.ParamLength {
+				copy(data[n:entry.ParamLength], make([]byte, entry.ParamLength-uint32(n)))
+			}
+
+			entry.ParamFormat = uint16(p.Format)
+			entry.ParamLength = uint32(len(p.Value))
+		}
+	} else {
+		var err error
+		index, keys, values, err = s.layout()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	keyTableOffset := sfoHeaderSize + len(index)*sfoEntrySize + len(gap)
+	header := sfoHeader{
+		Magic:             sfoMagic,
+		Version:           int32(s.Version),
+		KeyTableOffset:    int32(keyTableOffset),
+		DataTableOffset:   int32(keyTableOffset + len(keys)),
+		IndexTableEntries: int32(len(index)),
+	}
+
+	buf := bytes.Buffer{}
+	binary.Write(&buf, binary.LittleEndian, &header)
+	binary.Write(&buf, binary.LittleEndian, index)
+	buf.Write(gap)
+	buf.Write(keys)
+	buf.Write(values)
+
+	return buf.WriteTo(w)
+}
+
+func (pr *Reader) readSFO(r io.Reader) (n int64, err error) {
+	sfo := &SFO{}
+	n, err = sfo.Parse(r)
+	if err != nil {
+		return
+	}
+
+	pr.SFO = sfo
+	pr.SfoEntries = sfo.Map()
+
+	return
+}
+
+func align4(n uint32) uint32 {
+	return (n + 3) &^ 3
+}
@@ -0,0 +1,353 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/fs"
+	"testing"
+)
+
+type testSFOParam struct {
+	key       string
+	format    SFOFormat
+	maxLength uint32
+	value     []byte
+}
+
+// rawSFO lays out a SFO file by hand: gap is left between the index and the
+// key table, the key table is padded to keyAlign and the unused space of the
+// values is filled with fill.
+func rawSFO(params []testSFOParam, gap, keyAlign int, fill byte) []byte {
+	var keys, values []byte
+	index := make([]sfoIndexTableEntry, len(params))
+
+	for i, p := range params {
+		index[i] = sfoIndexTableEntry{
+			KeyOffset:      uint16(len(keys)),
+			ParamFormat:    uint16(p.format),
+			ParamLength:    uint32(len(p.value)),
+			ParamMaxLength: p.maxLength,
+			DataOffset:     uint32(len(values)),
+		}
+
+		keys = append(append(keys, p.key...), 0)
+		values = append(values, p.value...)
+		values = append(values, bytes.Repeat([]byte{fill}, int(p.maxLength)-len(p.value))...)
+	}
+
+	for len(keys)%keyAlign != 0 {
+		keys = append(keys, 0)
+	}
+
+	keyTableOffset := sfoHeaderSize + len(index)*sfoEntrySize + gap
+	header := sfoHeader{
+		Magic:             sfoMagic,
+		Version:           sfoVersion,
+		KeyTableOffset:    int32(keyTableOffset),
+		DataTableOffset:   int32(keyTableOffset + len(keys)),
+		IndexTableEntries: int32(len(index)),
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, header)
+	binary.Write(&buf, binary.LittleEndian, index)
+	buf.Write(make([]byte, gap))
+	buf.Write(keys)
+	buf.Write(values)
+
+	return buf.Bytes()
+}
+
+func sfoInt(n uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, n)
+	return b
+}
+
+var (
+	testVitaSFO = []testSFOParam{
+		{"APP_VER", SFOFormatUTF8, 8, []byte("01.00\x00")},
+		{"ATTRIBUTE", SFOFormatInteger, 4, sfoInt(0x8000)},
+		{"CATEGORY", SFOFormatUTF8, 4, []byte("gd\x00")},
+		{"CONTENT_ID", SFOFormatUTF8, 48, []byte(testContentID + "\x00")},
+		{"PSP2_SYSTEM_VER", SFOFormatInteger, 4, sfoInt(0x03600000)},
+		{"TITLE", SFOFormatUTF8, 128, []byte("Test Game\x00")},
+	}
+	testPSPSFO = []testSFOParam{
+		{"BOOTABLE", SFOFormatInteger, 4, sfoInt(1)},
+		{"CATEGORY", SFOFormatUTF8, 4, []byte("EG\x00")},
+		{"DISC_ID", SFOFormatUTF8, 16, []byte("ULUS00001\x00")},
+		{"PSP_SYSTEM_VER", SFOFormatUTF8, 8, []byte("6.60\x00")},
+		{"TITLE", SFOFormatUTF8, 128, []byte("Test Game\x00")},
+	}
+	testPS3SFO = []testSFOParam{
+		{"APP_VER", SFOFormatUTF8, 8, []byte("01.00\x00")},
+		{"CATEGORY", SFOFormatUTF8, 4, []byte("HG\x00")},
+		{"PS3_SYSTEM_VER", SFOFormatUTF8, 8, []byte("04.8000\x00")},
+		// a string without the NUL terminator, like the ones of the
+		// license files
+		{"PARAMS", SFOFormatUTF8Special, 12, []byte("special")},
+		{"TITLE", SFOFormatUTF8, 128, []byte("Test Game\x00")},
+	}
+)
+
+func TestSFORoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		params   []testSFOParam
+		gap      int
+		keyAlign int
+		fill     byte
+	}{
+		{"vita", testVitaSFO, 0, 4, 0},
+		{"psp", testPSPSFO, 0, 4, 0},
+		// stale bytes in the padding must be kept too
+		{"ps3", testPS3SFO, 12, 8, 0xaa},
+	}
+
+	for _, tt := range tests {
+		data := rawSFO(tt.params, tt.gap, tt.keyAlign, tt.fill)
+
+		sfo := NewSFO()
+		n, err := sfo.Parse(bytes.NewReader(append(data, "trailing data"...)))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if n != int64(len(data)) {
+			t.Errorf("%s: read %d bytes, want %d", tt.name, n, len(data))
+		}
+
+		if len(sfo.Params) != len(tt.params) {
+			t.Fatalf("%s: got %d params", tt.name, len(sfo.Params))
+		}
+
+		for i, p := range tt.params {
+			got := sfo.Params[i]
+			if got.Key != p.key || got.Format != p.format || got.MaxLength != p.maxLength ||
+				!bytes.Equal(got.Value, p.value) {
+				t.Errorf("%s: got param %+v", tt.name, got)
+			}
+		}
+
+		var out bytes.Buffer
+		if _, err := sfo.WriteTo(&out); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if !bytes.Equal(out.Bytes(), data) {
+			t.Errorf("%s: the written SFO doesn't match", tt.name)
+		}
+	}
+
+	// the PARAM.SFO of the test package
+	ra := openTestPackage(t, readTestdata(t, testVitaPkg))
+	data, err := fs.ReadFile(ra, "sce_sys/param.sfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sfo := NewSFO()
+	if _, err := sfo.Parse(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	sfo.WriteTo(&out)
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Error("the written param.sfo doesn't match")
+	}
+}
+
+func TestSFOValues(t *testing.T) {
+	sfo := NewSFO()
+	if _, err := sfo.Parse(bytes.NewReader(rawSFO(testPS3SFO, 0, 4, 0))); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"APP_VER":        "01.00",
+		"CATEGORY":       "HG",
+		"PS3_SYSTEM_VER": "04.8000",
+		"PARAMS":         "special",
+		"TITLE":          "Test Game",
+	}
+
+	m := sfo.Map()
+	if len(m) != len(expected) {
+		t.Fatalf("got %d values", len(m))
+	}
+
+	for key, value := range expected {
+		if m[key] != value {
+			t.Errorf("%s = %q, want %q", key, m[key], value)
+		}
+	}
+
+	if sfo.Get("TITLE").Int() != 0 || sfo.Get("MISSING") != nil {
+		t.Error("wrong string lookups")
+	}
+
+	title := sfo.Get("TITLE")
+	if err := title.SetInt(1); err == nil {
+		t.Error("set an integer on a string")
+	}
+
+	if err := title.SetString(string(make([]byte, 128))); err == nil {
+		t.Error("set a string longer than the max length")
+	}
+
+	if err := sfo.Get("PARAMS").SetString("twelve bytes"); err != nil {
+		t.Errorf("the special strings have no terminator: %v", err)
+	}
+
+	sfo = NewSFO()
+	sfo.Parse(bytes.NewReader(rawSFO(testVitaSFO, 0, 4, 0)))
+
+	attr := sfo.Get("ATTRIBUTE")
+	if attr.String() != "32768" || attr.Int() != 0x8000 {
+		t.Errorf("got attribute %s", attr)
+	}
+
+	if err := attr.SetString("1"); err == nil {
+		t.Error("set a string on an integer")
+	}
+}
+
+func TestSFOEdit(t *testing.T) {
+	data := rawSFO(testPS3SFO, 12, 8, 0xaa)
+
+	sfo := NewSFO()
+	if _, err := sfo.Parse(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sfo.Get("TITLE").SetString("Edit"); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if _, err := sfo.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	// the shorter value keeps the layout, the rest of the old value is
+	// cleared and the padding after it is kept
+	title := bytes.Index(data, []byte("Test Game\x00"))
+	expected := append([]byte{}, data...)
+	copy(expected[title:], "Edit\x00\x00\x00\x00\x00\x00")
+	binary.LittleEndian.PutUint32(expected[sfoHeaderSize+4*sfoEntrySize+4:], 5)
+
+	if !bytes.Equal(out.Bytes(), expected) {
+		t.Fatal("the edited SFO doesn't match")
+	}
+
+	edited := NewSFO()
+	if _, err := edited.Parse(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	if p := edited.Get("TITLE"); p.String() != "Edit" || p.MaxLength != 128 {
+		t.Fatalf("got title %q", p)
+	}
+}
+
+func TestSFOAddRemove(t *testing.T) {
+	sfo := NewSFO()
+	if _, err := sfo.Parse(bytes.NewReader(rawSFO(testPSPSFO, 12, 8, 0xaa))); err != nil {
+		t.Fatal(err)
+	}
+
+	if !sfo.Remove("BOOTABLE") || sfo.Remove("BOOTABLE") {
+		t.Fatal("wrong Remove result")
+	}
+
+	p, err := sfo.Add("PARENTAL_LEVEL", SFOFormatInteger, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetInt(5)
+
+	p, err = sfo.Add("REGION", SFOFormatUTF8, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetString("us")
+
+	if _, err := sfo.Add("TITLE", SFOFormatUTF8, 128); err == nil {
+		t.Error("added a duplicated key")
+	}
+
+	if _, err := sfo.Add("EMPTY", SFOFormatUTF8, 0); err == nil {
+		t.Error("added a string without space")
+	}
+
+	if _, err := sfo.Add("UNKNOWN", 0x0104, 4); err == nil {
+		t.Error("added an unknown format")
+	}
+
+	var out bytes.Buffer
+	if _, err := sfo.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	// the new layout drops the gap and the padding of the parsed file, the
+	// new keys are sorted with the others
+	expected := rawSFO([]testSFOParam{
+		{"CATEGORY", SFOFormatUTF8, 4, []byte("EG\x00")},
+		{"DISC_ID", SFOFormatUTF8, 16, []byte("ULUS00001\x00")},
+		{"PARENTAL_LEVEL", SFOFormatInteger, 4, sfoInt(5)},
+		{"PSP_SYSTEM_VER", SFOFormatUTF8, 8, []byte("6.60\x00")},
+		{"REGION", SFOFormatUTF8, 8, []byte("us\x00")},
+		{"TITLE", SFOFormatUTF8, 128, []byte("Test Game\x00")},
+	}, 0, 4, 0)
+
+	if !bytes.Equal(out.Bytes(), expected) {
+		t.Fatal("the SFO with new params doesn't match")
+	}
+
+	// a value longer than its max length can't be written
+	sfo.Get("REGION").Value = make([]byte, 9)
+	if _, err := sfo.WriteTo(&out); err == nil {
+		t.Fatal("wrote a value past its max length")
+	}
+}
+
+func TestSFOParseInvalid(t *testing.T) {
+	valid := rawSFO(testVitaSFO, 0, 4, 0)
+	entry := func(idx, field int) int {
+		return sfoHeaderSize + idx*sfoEntrySize + field
+	}
+
+	tests := []struct {
+		name  string
+		patch func(data []byte)
+	}{
+		{"magic", func(data []byte) { data[1] = 'X' }},
+		{"negative entries", func(data []byte) { binary.LittleEndian.PutUint32(data[0x10:], 0xffffffff) }},
+		{"too many entries", func(data []byte) { binary.LittleEndian.PutUint32(data[0x10:], 0x10000000) }},
+		{"key table in the index", func(data []byte) { binary.LittleEndian.PutUint32(data[0x8:], sfoHeaderSize) }},
+		{"data before keys", func(data []byte) { binary.LittleEndian.PutUint32(data[0xc:], 0) }},
+		{"huge data table", func(data []byte) { binary.LittleEndian.PutUint32(data[0xc:], 0x7fffffff) }},
+		{"key offset", func(data []byte) { binary.LittleEndian.PutUint16(data[entry(0, 0):], 0x1000) }},
+		{"length past max", func(data []byte) { binary.LittleEndian.PutUint32(data[entry(0, 4):], 9) }},
+		// the sum of the offset and the max length wraps in 32 bits
+		{"wrapping offset", func(data []byte) { binary.LittleEndian.PutUint32(data[entry(1, 12):], 0xfffffffc) }},
+		{"huge max length", func(data []byte) { binary.LittleEndian.PutUint32(data[entry(5, 8):], 0xffffff00) }},
+	}
+
+	for _, tt := range tests {
+		data := append([]byte{}, valid...)
+		tt.patch(data)
+
+		if _, err := NewSFO().Parse(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: parsed an invalid SFO", tt.name)
+		}
+	}
+
+	for _, size := range []int{0, sfoHeaderSize, sfoHeaderSize + sfoEntrySize, len(valid) - 1} {
+		if _, err := NewSFO().Parse(bytes.NewReader(valid[:size])); err == nil {
+			t.Errorf("parsed a SFO truncated to %d bytes", size)
+		}
+	}
+}
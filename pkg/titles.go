@@ -0,0 +1,148 @@
+package pkg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Language is a system language, the value is the index used in the
+// TITLE_xx and STITLE_xx parameters.
+type Language int
+
+const (
+	LanguageJapanese           Language = 0
+	LanguageEnglish            Language = 1
+	LanguageFrench             Language = 2
+	LanguageSpanish            Language = 3
+	LanguageGerman             Language = 4
+	LanguageItalian            Language = 5
+	LanguageDutch              Language = 6
+	LanguagePortuguese         Language = 7
+	LanguageRussian            Language = 8
+	LanguageKorean             Language = 9
+	LanguageChineseTraditional Language = 10
+	LanguageChineseSimplified  Language = 11
+	LanguageFinnish            Language = 12
+	LanguageSwedish            Language = 13
+	LanguageDanish             Language = 14
+	LanguageNorwegian          Language = 15
+	LanguagePolish             Language = 16
+	LanguagePortugueseBrazil   Language = 17
+	LanguageEnglishUK          Language = 18
+	LanguageTurkish            Language = 19
+	// LanguageSpanishLatinAmerica is the last TITLE_xx index of the SFO files
+	LanguageSpanishLatinAmerica Language = 20
+)
+
+// maximum language index of the title parameters, TITLE_20
+const maxTitleLanguage = LanguageSpanishLatinAmerica
+
+var languageCodes = map[Language]string{
+	LanguageJapanese:            "ja",
+	LanguageEnglish:             "en",
+	LanguageFrench:              "fr",
+	LanguageSpanish:             "es",
+	LanguageGerman:              "de",
+	LanguageItalian:             "it",
+	LanguageDutch:               "nl",
+	LanguagePortuguese:          "pt",
+	LanguageRussian:             "ru",
+	LanguageKorean:              "ko",
+	LanguageChineseTraditional:  "zh-tw",
+	LanguageChineseSimplified:   "zh-cn",
+	LanguageFinnish:             "fi",
+	LanguageSwedish:             "sv",
+	LanguageDanish:              "da",
+	LanguageNorwegian:           "no",
+	LanguagePolish:              "pl",
+	LanguagePortugueseBrazil:    "pt-br",
+	LanguageEnglishUK:           "en-gb",
+	LanguageTurkish:             "tr",
+	LanguageSpanishLatinAmerica: "es-419",
+}
+
+// String returns the language code, like en or pt-br.
+func (l Language) String() string {
+	if code, ok := languageCodes[l]; ok {
+		return code
+	}
+
+	return fmt.Sprintf("language %d", int(l))
+}
+
+// ParseLanguage decodes a language code, like en or pt-br, or a language
+// index.
+func ParseLanguage(s string) (Language, error) {
+	s = strings.ToLower(strings.Replace(s, "_", "-", -1))
+
+	for lang, code := range languageCodes {
+		if code == s {
+			return lang, nil
+		}
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > int(maxTitleLanguage) {
+		return 0, fmt.Errorf("unknown language: %s", s)
+	}
+
+	return Language(n), nil
+}
+
+// Titles returns the localized titles of the SFO. The short title (STITLE_xx)
+// is used for the languages without a title.
+func (s *SFO) Titles() map[Language]string {
+	titles := map[Language]string{}
+
+	for lang := Language(0); lang <= maxTitleLanguage; lang++ {
+		if title := s.localizedTitle(lang); title != "" {
+			titles[lang] = title
+		}
+	}
+
+	return titles
+}
+
+// TitleFor returns the title in the first of the languages that has one,
+// falling back to the default title, the TITLE or the STITLE parameter.
+func (s *SFO) TitleFor(langs ...Language) string {
+	for _, l := range langs {
+		if title := s.localizedTitle(l); title != "" {
+			return title
+		}
+	}
+
+	if title := s.stringValue("TITLE"); title != "" {
+		return title
+	}
+
+	return s.stringValue("STITLE")
+}
+
+func (s *SFO) localizedTitle(lang Language) string {
+	if title := s.stringValue(fmt.Sprintf("TITLE_%02d", int(lang))); title != "" {
+		return title
+	}
+
+	return s.stringValue(fmt.Sprintf("STITLE_%02d", int(lang)))
+}
+
+// Titles returns the localized titles of the package, see SFO.Titles.
+func (pr *Reader) Titles() map[Language]string {
+	if pr.SFO == nil {
+		return map[Language]string{}
+	}
+
+	return pr.SFO.Titles()
+}
+
+// TitleFor returns the title of the package in the first of the languages
+// that has one, falling back to GetTitle.
+func (pr *Reader) TitleFor(langs ...Language) string {
+	if pr.SFO == nil {
+		return pr.GetTitle()
+	}
+
+	return pr.SFO.TitleFor(langs...)
+}
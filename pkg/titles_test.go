@@ -0,0 +1,144 @@
+package pkg
+
+import (
+	"bytes"
+	"testing"
+)
+
+func testTitleSFO(t *testing.T, titles map[string]string) *SFO {
+	sfo := NewSFO()
+	for key, title := range titles {
+		p, err := sfo.Add(key, SFOFormatUTF8, 0x80)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := p.SetString(title); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// written and parsed back, like the SFO of a package
+	var buf bytes.Buffer
+	if _, err := sfo.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	sfo = NewSFO()
+	if _, err := sfo.Parse(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	return sfo
+}
+
+func TestTitles(t *testing.T) {
+	sfo := testTitleSFO(t, map[string]string{
+		"TITLE":     "Default",
+		"TITLE_00":  "Japanese",
+		"STITLE_01": "English short",
+		"TITLE_02":  "French",
+		"STITLE_02": "French short",
+		"TITLE_18":  "English UK",
+		"TITLE_20":  "Spanish Latin America",
+		// past the last title index
+		"TITLE_21": "Unknown",
+	})
+
+	expected := map[Language]string{
+		LanguageJapanese:            "Japanese",
+		LanguageEnglish:             "English short",
+		LanguageFrench:              "French",
+		LanguageEnglishUK:           "English UK",
+		LanguageSpanishLatinAmerica: "Spanish Latin America",
+	}
+
+	titles := sfo.Titles()
+	if len(titles) != len(expected) {
+		t.Fatalf("got titles %v", titles)
+	}
+
+	for lang, title := range expected {
+		if titles[lang] != title {
+			t.Errorf("%s: got %q, want %q", lang, titles[lang], title)
+		}
+	}
+}
+
+func TestTitleFor(t *testing.T) {
+	sfo := testTitleSFO(t, map[string]string{
+		"TITLE":     "Default",
+		"STITLE":    "Short",
+		"TITLE_00":  "Japanese",
+		"STITLE_01": "English short",
+	})
+
+	tests := []struct {
+		langs []Language
+		title string
+	}{
+		{[]Language{LanguageJapanese}, "Japanese"},
+		{[]Language{LanguageEnglish, LanguageJapanese}, "English short"},
+		// the first language with a title wins
+		{[]Language{LanguageGerman, LanguageJapanese, LanguageEnglish}, "Japanese"},
+		{[]Language{LanguageGerman, LanguageFrench}, "Default"},
+	}
+
+	for _, tt := range tests {
+		if title := sfo.TitleFor(tt.langs...); title != tt.title {
+			t.Errorf("%v: got %q, want %q", tt.langs, title, tt.title)
+		}
+	}
+
+	// the default title without languages
+	if title := sfo.TitleFor(); title != "Default" {
+		t.Errorf("got %q, want the default title", title)
+	}
+
+	// STITLE is the last fallback
+	sfo = testTitleSFO(t, map[string]string{"STITLE": "Short"})
+	if title := sfo.TitleFor(LanguageEnglish); title != "Short" {
+		t.Errorf("got %q, want the short title", title)
+	}
+
+	if title := NewSFO().TitleFor(LanguageEnglish); title != "" {
+		t.Errorf("got %q without titles", title)
+	}
+}
+
+func TestParseLanguage(t *testing.T) {
+	tests := []struct {
+		in   string
+		lang Language
+	}{
+		{"ja", LanguageJapanese},
+		{"en", LanguageEnglish},
+		{"EN", LanguageEnglish},
+		{"pt", LanguagePortuguese},
+		{"pt-br", LanguagePortugueseBrazil},
+		{"pt_BR", LanguagePortugueseBrazil},
+		{"zh-TW", LanguageChineseTraditional},
+		{"en-gb", LanguageEnglishUK},
+		{"0", LanguageJapanese},
+		{"19", LanguageTurkish},
+		{"es-419", LanguageSpanishLatinAmerica},
+		{"20", LanguageSpanishLatinAmerica},
+	}
+
+	for _, tt := range tests {
+		lang, err := ParseLanguage(tt.in)
+		if err != nil || lang != tt.lang {
+			t.Errorf("%q: got %v, %v, want %v", tt.in, lang, err, tt.lang)
+		}
+	}
+
+	for _, in := range []string{"", "xx", "english", "-1", "21"} {
+		if _, err := ParseLanguage(in); err == nil {
+			t.Errorf("parsed the unknown language %q", in)
+		}
+	}
+
+	if LanguageTurkish.String() != "tr" || Language(21).String() != "language 21" {
+		t.Error("wrong language names")
+	}
+}
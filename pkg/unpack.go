@@ -6,21 +6,213 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
 	"strings"
 )
 
-func loadSFO(w pkgWriter, pr *Reader, entry *fileEntry) error {
+func loadSFO(w pkgWriter, pr *Reader, name string) error {
 	sfo := bytes.Buffer{}
 	sfoWriter := io.TeeReader(pr, &sfo)
-	err := w.CreateFile(entry.Name, sfoWriter)
+	err := w.CreateFile(name, sfoWriter)
 	_, err = pr.readSFO(&sfo)
 
 	return err
 }
 
+// loadPSMAppInfo writes the app.info file of a PSM package, reading the
+// title from it.
+func loadPSMAppInfo(w pkgWriter, pr *Reader, name string) error {
+	appInfo := bytes.Buffer{}
+	err := w.CreateFile(name, io.TeeReader(pr, &appInfo))
+	if err != nil {
+		return err
+	}
+
+	pr.psmTitle, err = readPSMTitle(&appInfo)
+	return err
+}
+
+type PSPOutputMode int
+
+const (
+	// PSPOutputPBP extracts the EBOOT.PBP as stored in the package
+	PSPOutputPBP PSPOutputMode = iota
+	// PSPOutputISO converts the EBOOT.PBP to a plain ISO image
+	PSPOutputISO
+	// PSPOutputCSO converts the EBOOT.PBP to a deflate compressed CSO image
+	PSPOutputCSO
+	// PSPOutputZSO converts the EBOOT.PBP to a LZ4 compressed ZSO image
+	PSPOutputZSO
+)
+
+// imageName returns the file name of the PSP image with the given extension.
+func (pr *Reader) imageName(ext string) string {
+	title := pr.GetTitle()
+	if title == "" {
+		return fmt.Sprintf("%s.%s", pr.GetTitleID(), ext)
+	}
+
+	return fmt.Sprintf("%s [%s] [%s].%s", title, pr.GetTitleID(), pr.GetRegion(), ext)
+}
+
+// convertEBOOT writes the ISO image of the current EBOOT.PBP entry.
+func (pr *Reader) convertEBOOT(w pkgWriter) error {
+	// the PBP sections are read out of order so spool it first
+	tmp, err := ioutil.TempFile("", "eboot")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, pr)
+	if err != nil {
+		return err
+	}
+
+	img, err := OpenPBPImage(tmp, size)
+	if err != nil {
+		return err
+	}
+
+	if pr.PSPOutput == PSPOutputISO {
+		pipeReader, pipeWriter := io.Pipe()
+		go func() {
+			_, err := img.WriteTo(pipeWriter)
+			pipeWriter.CloseWithError(err)
+		}()
+
+		err = w.CreateFile(pr.imageName("iso"), pipeReader)
+		pipeReader.CloseWithError(err)
+
+		return err
+	}
+
+	opts := pr.CSOOptions
+	ext := "cso"
+	if pr.PSPOutput == PSPOutputZSO {
+		opts.Format = CSOFormatZSO
+		ext = "zso"
+	} else {
+		opts.Format = CSOFormatCSO
+	}
+
+	// the block index is written at the end, so the image needs a seekable
+	// file. It's spooled to a temporary one when the writer can't provide
+	// it, the CSOWriter would keep the whole image in memory otherwise
+	name := pr.imageName(ext)
+	if sw, ok := w.(seekablePkgWriter); ok {
+		f, err := sw.CreateSeekableFile(name)
+		if err != nil {
+			return err
+		}
+
+		if err = writeCSO(f, img, &opts); err != nil {
+			f.Close()
+			return err
+		}
+
+		return f.Close()
+	}
+
+	cso, err := ioutil.TempFile("", "image")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(cso.Name())
+	defer cso.Close()
+
+	if err = writeCSO(cso, img, &opts); err != nil {
+		return err
+	}
+
+	if _, err = cso.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	return w.CreateFile(name, cso)
+}
+
+// writeCSO compresses the ISO image of img into w.
+func writeCSO(w io.Writer, img *NPUMDIMG, opts *CSOOptions) error {
+	cw, err := NewCSOWriter(w, img.Size(), opts)
+	if err != nil {
+		return err
+	}
+
+	if _, err = img.WriteTo(cw); err != nil {
+		return err
+	}
+
+	return cw.Close()
+}
+
+// isEDATEntry reports whether the entry may be an EDAT or SDAT file.
+func isEDATEntry(entry *Entry) bool {
+	ext := strings.ToLower(path.Ext(entry.name))
+	return entry.FileType() == FileTypeFileEdat || ext == ".edat" || ext == ".sdat"
+}
+
+// decryptEDAT writes the decrypted contents of the current EDAT entry. Files
+// without the NPD header are written as is.
+func (pr *Reader) decryptEDAT(w pkgWriter, name string) error {
+	// the block metadata is read out of order so spool it first
+	tmp, err := ioutil.TempFile("", "edat")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err = io.Copy(tmp, pr); err != nil {
+		return err
+	}
+
+	magic := make([]byte, len(npdMagic))
+	if _, err = tmp.ReadAt(magic, 0); err != nil && err != io.EOF {
+		return err
+	}
+
+	if !IsEDAT(magic) {
+		if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		return w.CreateFile(name, tmp)
+	}
+
+	edat, err := OpenEDAT(tmp, pr.EDATKey)
+	if err != nil {
+		return err
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		_, err := edat.WriteTo(pipeWriter)
+		pipeWriter.CloseWithError(err)
+	}()
+
+	err = w.CreateFile(name, pipeReader)
+	pipeReader.CloseWithError(err)
+
+	return err
+}
+
+// psoneFiles are the files extracted from PSOne packages, the rest are ignored.
+var psoneFiles = map[string]bool{
+	"EBOOT.PBP":    true,
+	"DOCUMENT.DAT": true,
+	"KEYS.BIN":     true,
+}
+
 func (pr *Reader) unpackLoop(w pkgWriter) error {
+	dirs := map[string]bool{}
+
 	for {
 		entry, err := pr.Next()
 		if err == io.EOF {
@@ -31,17 +223,48 @@ func (pr *Reader) unpackLoop(w pkgWriter) error {
 			return err
 		}
 
+		name := pr.entryPath(entry)
+		isSFO := strings.HasSuffix(entry.name, "PARAM.SFO") && len(pr.SfoEntries) == 0
+
+		if name == "" {
+			// not extracted, but the title is still needed
+			if isSFO {
+				_, err = pr.readSFO(pr)
+				if err != nil {
+					return err
+				}
+			}
+
+			continue
+		}
+
+		if pr.pkgType == PackageTypePSOne && !dirs[path.Dir(name)] {
+			// the game folders don't exist as entries in the package
+			err := w.CreateDir(path.Dir(name))
+			if err != nil {
+				return err
+			}
+
+			dirs[path.Dir(name)] = true
+		}
+
 		switch {
 		case entry.IsDirectory():
-			err := w.CreateDir(entry.Name)
+			err := w.CreateDir(name)
 			if err != nil {
 				return err
 			}
 		case entry.IsFile():
-			if strings.HasSuffix(entry.Name, "PARAM.SFO") && len(pr.SfoEntries) == 0 {
-				err = loadSFO(w, pr, entry)
+			if pr.pkgType == PackageTypePSP && pr.PSPOutput != PSPOutputPBP && path.Base(entry.name) == "EBOOT.PBP" {
+				err = pr.convertEBOOT(w)
+			} else if pr.DecryptEDAT && isEDATEntry(entry) {
+				err = pr.decryptEDAT(w, name)
+			} else if isSFO {
+				err = loadSFO(w, pr, name)
+			} else if pr.pkgType == PackageTypePSM && entry.name == psmAppInfo {
+				err = loadPSMAppInfo(w, pr, name)
 			} else {
-				err = w.CreateFile(entry.Name, pr)
+				err = w.CreateFile(name, pr)
 			}
 
 			if err != nil {
@@ -52,12 +275,50 @@ func (pr *Reader) unpackLoop(w pkgWriter) error {
 		}
 	}
 
-	if pr.pkgType != PackageTypeVitaDLC &&
-		pr.pkgType != PackageTypeVitaApp &&
-		pr.pkgType != PackageTypeVitaPatch {
+	switch pr.pkgType {
+	case PackageTypeVitaDLC, PackageTypeVitaApp, PackageTypeVitaPatch:
+		return pr.createVitaFiles(w)
+	case PackageTypePSM:
+		return pr.createPSMFiles(w)
+	default:
 		return nil
 	}
+}
+
+// entryPath returns the path where the entry is extracted, relative to basedir.
+// An empty path means that the entry is skipped.
+func (pr *Reader) entryPath(entry *Entry) string {
+	name := entry.name
+
+	switch pr.pkgType {
+	case PackageTypePSOne:
+		if entry.IsDirectory() || !psoneFiles[path.Base(name)] {
+			return ""
+		}
 
+		// single title packages keep the game in USRDIR/CONTENT, multi-title
+		// bundles use a folder per title
+		folder := path.Base(path.Dir(name))
+		if folder == "CONTENT" || folder == "." {
+			folder = pr.GetTitleID()
+		}
+
+		return path.Join(folder, path.Base(name))
+	case PackageTypePSM:
+		// the application data lives in the read-only folder
+		if name == "contents" {
+			return "RO"
+		}
+
+		if strings.HasPrefix(name, "contents/") {
+			return path.Join("RO", strings.TrimPrefix(name, "contents/"))
+		}
+	}
+
+	return name
+}
+
+func (pr *Reader) createVitaFiles(w pkgWriter) error {
 	err := w.CreateDir("sce_sys/package")
 	if err != nil {
 		return err
@@ -81,6 +342,36 @@ func (pr *Reader) unpackLoop(w pkgWriter) error {
 	return nil
 }
 
+// createPSMFiles creates the writable folders, the system files and the
+// license expected by the PSM runtime.
+func (pr *Reader) createPSMFiles(w pkgWriter) error {
+	for _, dir := range []string{"RO/License", "RW/Documents", "RW/Temp", "RW/System"} {
+		err := w.CreateDir(dir)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := w.CreateFile("RW/System/content_id", strings.NewReader(pr.FileHeader.GetContentID()))
+	if err != nil {
+		return err
+	}
+
+	err = w.CreateFile("RW/System/pm.dat", bytes.NewReader(make([]byte, 0x10000)))
+	if err != nil {
+		return err
+	}
+
+	if len(pr.rif) > 0 {
+		err = w.CreateFile("RO/License/FAKE.rif", bytes.NewReader(pr.rif))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (pr *Reader) Unpack(outDir string) error {
 	titleid := pr.GetTitleID()
 
@@ -93,11 +384,15 @@ func (pr *Reader) Unpack(outDir string) error {
 		contentName := pr.FileHeader.GetContentName()
 		basedir = path.Join(outDir, "cont", titleid, contentName)
 	case PackageTypeVitaPatch:
-		appVer := pr.SfoEntries["APP_VER"]
-		appVer = strings.TrimLeft(appVer, "0")
 		basedir = path.Join(outDir, "patch", titleid)
 	case PackageTypePSP:
 		basedir = path.Join(outDir, "pspemu/ISO")
+	case PackageTypePSOne:
+		basedir = path.Join(outDir, "pspemu/PSP/GAME")
+	case PackageTypePSM:
+		basedir = path.Join(outDir, "psm", titleid)
+	case PackageTypePS3Game, PackageTypePS3PS2, PackageTypePS3Theme, PackageTypePS3Avatar:
+		basedir = path.Join(outDir, pr.ps3Dir())
 	}
 
 	err := os.MkdirAll(basedir, 0755)
@@ -108,14 +403,12 @@ func (pr *Reader) Unpack(outDir string) error {
 	return pr.unpackLoop(&fsPkgWriter{basedir: basedir})
 }
 
-func (pr *Reader) CreateZip(outDir string) error {
+// zipLayout returns the base directory inside the zip and the zip file name.
+func (pr *Reader) zipLayout() (basedir, filename string) {
 	title := pr.GetTitle()
 	titleid := pr.GetTitleID()
 	region := pr.GetRegion()
 
-	var filename string
-	var basedir string
-
 	switch pr.PackageType() {
 	case PackageTypeVitaApp:
 		basedir = path.Join("app", titleid)
@@ -125,10 +418,16 @@ func (pr *Reader) CreateZip(outDir string) error {
 		basedir = path.Join("cont", titleid, contentName)
 		filename = fmt.Sprintf("%s [%s] [%s] [%s].zip", title, titleid, region, contentName)
 	case PackageTypeVitaPatch:
-		appVer := pr.SfoEntries["APP_VER"]
-		appVer = strings.TrimLeft(appVer, "0")
+		appVer := pr.AppParams().AppVersion
 		basedir = path.Join("patch", titleid)
 		filename = fmt.Sprintf("%s [%s] [%s] [PATCH] [v%s].zip", title, titleid, region, appVer)
+	case PackageTypePSM:
+		basedir = path.Join("psm", titleid)
+		if title == "" {
+			filename = fmt.Sprintf("%s [%s] [PSM].zip", titleid, region)
+		} else {
+			filename = fmt.Sprintf("%s [%s] [%s] [PSM].zip", title, titleid, region)
+		}
 	case PackageTypePSP:
 		basedir = path.Join("pspemu", titleid)
 		if title == "" {
@@ -136,18 +435,63 @@ func (pr *Reader) CreateZip(outDir string) error {
 		} else {
 			filename = fmt.Sprintf("%s [%s] [%s].zip", title, titleid, region)
 		}
+	case PackageTypePSOne:
+		basedir = "pspemu/PSP/GAME"
+		if title == "" {
+			filename = fmt.Sprintf("%s.zip", titleid)
+		} else {
+			filename = fmt.Sprintf("%s [%s] [%s].zip", title, titleid, region)
+		}
+	case PackageTypePS3Game, PackageTypePS3PS2:
+		basedir = pr.ps3Dir()
+		if title == "" {
+			filename = fmt.Sprintf("%s [%s] [PS3].zip", titleid, region)
+		} else {
+			filename = fmt.Sprintf("%s [%s] [%s] [PS3].zip", title, titleid, region)
+		}
+	case PackageTypePS3Theme, PackageTypePS3Avatar:
+		basedir = pr.ps3Dir()
+		filename = fmt.Sprintf("%s [%s] [%s].zip", pr.FileHeader.GetContentName(), titleid, region)
 	}
 
+	return
+}
+
+// ps3Dir returns the install folder of a PS3 package, relative to the root of
+// the PS3 filesystem.
+func (pr *Reader) ps3Dir() string {
+	switch pr.PackageType() {
+	case PackageTypePS3Theme:
+		// themes are a single .p3t file
+		return "dev_hdd0/theme"
+	case PackageTypePS3Avatar:
+		return path.Join("dev_hdd0/avatar", pr.FileHeader.GetContentName())
+	default:
+		return path.Join("dev_hdd0/game", pr.GetTitleID())
+	}
+}
+
+func (pr *Reader) CreateZip(outDir string) error {
+	basedir, _ := pr.zipLayout()
+	return pr.writeZip(outDir, basedir, pr.unpackLoop)
+}
+
+// writeZip creates the zip named by zipLayout in outDir, with the files
+// written by unpack under basedir.
+func (pr *Reader) writeZip(outDir, basedir string, unpack func(w pkgWriter) error) error {
+	_, filename := pr.zipLayout()
 	filepath := path.Join(outDir, filename)
 
-	if title == "" {
+	if pr.GetTitle() == "" {
 		// rename file after unpacking it, the internal sfo will be read then
-		defer func(oldpath, titleid, region string) {
-			title := pr.GetTitle()
-			filename = fmt.Sprintf("%s [%s] [%s].zip", title, titleid, region)
-			filepath := path.Join(outDir, filename)
-			os.Rename(oldpath, filepath)
-		}(filepath, titleid, region)
+		defer func(oldpath string) {
+			if pr.GetTitle() == "" {
+				return
+			}
+
+			_, filename := pr.zipLayout()
+			os.Rename(oldpath, path.Join(outDir, filename))
+		}(filepath)
 	}
 
 	zf, err := os.Create(filepath)
@@ -160,5 +504,5 @@ func (pr *Reader) CreateZip(outDir string) error {
 	zipWriter := zip.NewWriter(zf)
 	defer zipWriter.Close()
 
-	return pr.unpackLoop(&zipPkgWriter{zipWriter: zipWriter, basedir: basedir})
+	return unpack(&zipPkgWriter{zipWriter: zipWriter, basedir: basedir})
 }
@@ -0,0 +1,381 @@
+package pkg
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// withContentType replaces the content type of a package made by Writer,
+// the first metadata record after the DRM type.
+func withContentType(data []byte, contentType ContentTypeEnum) []byte {
+	data = append([]byte(nil), data...)
+	binary.BigEndian.PutUint32(data[builderInfoOffset+12+8:], uint32(contentType))
+
+	tailOffset := len(data) - fileHashSize
+	sum := sha1.Sum(data[:tailOffset])
+	copy(data[tailOffset:], sum[:])
+
+	return data
+}
+
+// testSFO builds a PARAM.SFO with the params as UTF-8 strings, laid out by
+// hand with the keys sorted.
+func testSFO(t *testing.T, params map[string]string) []byte {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	index := make([]sfoIndexTableEntry, len(keys))
+	var keyTable, dataTable bytes.Buffer
+
+	for idx, key := range keys {
+		value := params[key] + "\x00"
+		index[idx] = sfoIndexTableEntry{
+			KeyOffset:      uint16(keyTable.Len()),
+			ParamFormat:    uint16(SFOFormatUTF8),
+			ParamLength:    uint32(len(value)),
+			ParamMaxLength: 0x80,
+			DataOffset:     uint32(dataTable.Len()),
+		}
+
+		keyTable.WriteString(key + "\x00")
+		dataTable.WriteString(value)
+		dataTable.Write(make([]byte, 0x80-len(value)))
+	}
+
+	keyTable.Write(make([]byte, -keyTable.Len()&3))
+
+	header := sfoHeader{Magic: sfoMagic, Version: 0x101, IndexTableEntries: int32(len(index))}
+	header.KeyTableOffset = int32(binary.Size(header) + binary.Size(index))
+	header.DataTableOffset = header.KeyTableOffset + int32(keyTable.Len())
+
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, header); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := binary.Write(&buf, binary.LittleEndian, index); err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Write(keyTable.Bytes())
+	buf.Write(dataTable.Bytes())
+
+	return buf.Bytes()
+}
+
+func TestUnpackPS3(t *testing.T) {
+	fsys := fstest.MapFS{
+		"USRDIR/ISO.BIN.EDAT":      {Data: []byte("edat")},
+		"USRDIR/CONTENT/EBOOT.PBP": {Data: []byte("eboot")},
+		"PARAM.SFO":                {Data: testSFO(t, map[string]string{"TITLE": "Game", "CATEGORY": "1P"})},
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter("UP9000-NPUJ00000_00-0000000000000000", ContentTypeGameExec, 0)
+	if err := w.WriteFS(&buf, fsys); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		contentType ContentTypeEnum
+		pkgType     PackageType
+	}{
+		{ContentTypeGameExec, PackageTypePS3Game},
+		{ContentTypeGameData, PackageTypePS3Game},
+		{ContentTypePS2, PackageTypePS3PS2},
+	}
+
+	for _, tt := range tests {
+		r, err := NewReader(bytes.NewReader(withContentType(buf.Bytes(), tt.contentType)), "")
+		if err != nil {
+			t.Fatalf("%v: %v", tt.contentType, err)
+		}
+
+		if r.PackageType() != tt.pkgType {
+			t.Errorf("%v: package type %s, want %s", tt.contentType, r.PackageType(), tt.pkgType)
+		}
+
+		dir := t.TempDir()
+		if err := r.Unpack(dir); err != nil {
+			t.Fatalf("%v: %v", tt.contentType, err)
+		}
+
+		for name, file := range fsys {
+			data, err := ioutil.ReadFile(filepath.Join(dir, "dev_hdd0/game/NPUJ00000", name))
+			if err != nil || !bytes.Equal(data, file.Data) {
+				t.Errorf("%v: %s wasn't extracted: %v", tt.contentType, name, err)
+			}
+		}
+	}
+}
+
+// withPS3HeaderType changes the header type of a package made by Writer to
+// the one of the PS3, used by the PSN packages of the PSP and the PSOne too.
+func withPS3HeaderType(data []byte) []byte {
+	data = append([]byte(nil), data...)
+	binary.BigEndian.PutUint16(data[6:], fileTypePS3)
+
+	tailOffset := len(data) - fileHashSize
+	sum := sha1.Sum(data[:tailOffset])
+	copy(data[tailOffset:], sum[:])
+
+	return data
+}
+
+func TestPSNPackagesWithPS3Header(t *testing.T) {
+	fsys := fstest.MapFS{
+		"USRDIR/CONTENT/EBOOT.PBP": {Data: randomData(1000)},
+	}
+
+	tests := []struct {
+		contentType ContentTypeEnum
+		pkgType     PackageType
+	}{
+		{ContentTypePSP, PackageTypePSP},
+		{ContentTypeMinis, PackageTypePSP},
+		{ContentTypePS1, PackageTypePSOne},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		w := NewWriter("UP9000-NPUZ00000_00-0000000000000000", tt.contentType, 1)
+		if err := w.WriteFS(&buf, fsys); err != nil {
+			t.Fatal(err)
+		}
+
+		data := withPS3HeaderType(buf.Bytes())
+
+		// decrypted with the key type of the extended header, not the PS3 key
+		r, files := readPackage(t, data)
+		if !r.FileHeader.IsPS3() || r.PackageType() != tt.pkgType {
+			t.Errorf("%v: package type %s, want %s", tt.contentType, r.PackageType(), tt.pkgType)
+		}
+
+		if !bytes.Equal(files["USRDIR/CONTENT/EBOOT.PBP"], fsys["USRDIR/CONTENT/EBOOT.PBP"].Data) {
+			t.Errorf("%v: EBOOT.PBP wasn't decrypted", tt.contentType)
+		}
+	}
+}
+
+// testPSMPackage builds a PSM package with an app.info and the license
+// needed to extract it.
+func testPSMPackage(t *testing.T) (data, rif []byte) {
+	const contentID = "UP0001-NPNA00001_00-0000000000000000"
+
+	fsys := fstest.MapFS{
+		"contents/Application/app.exe": {Data: randomData(1000)},
+		"contents/Application/app.info": {Data: []byte(`<?xml version="1.0" encoding="utf-8"?>
+<application project_name="Test" version="1.00" default_locale="en-US">
+  <name>
+    <localized_item locale="ja-JP" value="テスト" />
+    <localized_item locale="en-US" value="PSM Game" />
+  </name>
+</application>
+`)},
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(contentID, ContentTypePSM2, 2)
+	if err := w.WriteFS(&buf, fsys); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes(), testPSMRif(contentID, fakeAccountID, 0, 0)
+}
+
+func TestUnpackPSM(t *testing.T) {
+	data, rif := testPSMPackage(t)
+
+	r, err := NewReaderWithOptions(bytes.NewReader(data), &ReaderOptions{RIF: rif})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.PackageType() != PackageTypePSM {
+		t.Fatalf("package type %s, want PSM", r.PackageType())
+	}
+
+	dir := t.TempDir()
+	if err := r.Unpack(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	base := filepath.Join(dir, "psm/NPNA00001")
+	files := map[string][]byte{
+		"RO/Application/app.exe": randomData(1000),
+		"RO/License/FAKE.rif":    rif,
+		"RW/System/content_id":   []byte("UP0001-NPNA00001_00-0000000000000000"),
+		"RW/System/pm.dat":       make([]byte, 0x10000),
+	}
+
+	for name, contents := range files {
+		data, err := ioutil.ReadFile(filepath.Join(base, name))
+		if err != nil || !bytes.Equal(data, contents) {
+			t.Errorf("%s wasn't extracted: %v", name, err)
+		}
+	}
+
+	for _, name := range []string{"RO/Application/app.info", "RW/Documents", "RW/Temp"} {
+		if _, err := os.Stat(filepath.Join(base, name)); err != nil {
+			t.Errorf("%s wasn't created: %v", name, err)
+		}
+	}
+
+	if r.GetTitle() != "PSM Game" {
+		t.Errorf("got title %q", r.GetTitle())
+	}
+}
+
+func TestCreateZipPSM(t *testing.T) {
+	data, rif := testPSMPackage(t)
+
+	r, err := NewReaderWithOptions(bytes.NewReader(data), &ReaderOptions{RIF: rif})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := r.CreateZip(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// named after the app.info title once it has been read
+	zr, err := zip.OpenReader(filepath.Join(dir, "PSM Game [NPNA00001] [USA] [PSM].zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer zr.Close()
+
+	names := map[string]bool{}
+	for _, f := range zr.File {
+		names[f.Name] = true
+	}
+
+	for _, name := range []string{
+		"psm/NPNA00001/RO/Application/app.exe",
+		"psm/NPNA00001/RO/Application/app.info",
+		"psm/NPNA00001/RO/License/FAKE.rif",
+		"psm/NPNA00001/RW/System/content_id",
+		"psm/NPNA00001/RW/System/pm.dat",
+	} {
+		if !names[name] {
+			t.Errorf("%s isn't in the zip", name)
+		}
+	}
+}
+
+func TestReadPSMTitle(t *testing.T) {
+	tests := []struct {
+		appInfo, title string
+	}{
+		{`<application default_locale="en-US"><name><localized_item locale="ja-JP" value="Japanese" /><localized_item locale="en-US" value="English" /></name></application>`, "English"},
+		// the first name without a matching default locale
+		{`<application><name><localized_item locale="ja-JP" value="Japanese" /><localized_item locale="en-US" value="English" /></name></application>`, "Japanese"},
+		{`<application></application>`, ""},
+	}
+
+	for _, tt := range tests {
+		title, err := readPSMTitle(strings.NewReader(tt.appInfo))
+		if err != nil || title != tt.title {
+			t.Errorf("got %q, %v, want %q", title, err, tt.title)
+		}
+	}
+
+	if _, err := readPSMTitle(strings.NewReader("<application")); err == nil {
+		t.Error("invalid XML was accepted")
+	}
+}
+
+func TestUnpackPSOne(t *testing.T) {
+	fsys := fstest.MapFS{
+		"PARAM.SFO":                   {Data: testSFO(t, map[string]string{"TITLE": "PSOne Game", "CATEGORY": "ME"})},
+		"USRDIR/CONTENT/EBOOT.PBP":    {Data: []byte("eboot")},
+		"USRDIR/CONTENT/DOCUMENT.DAT": {Data: []byte("document")},
+		"USRDIR/CONTENT/KEYS.BIN":     {Data: []byte("keys")},
+		"USRDIR/CONTENT/ICON0.PNG":    {Data: []byte("icon")},
+		// the other titles of a bundle
+		"USRDIR/GAME2/EBOOT.PBP": {Data: []byte("eboot 2")},
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter("UP9000-NPUF00000_00-0000000000000000", ContentTypePS1, 1)
+	if err := w.WriteFS(&buf, fsys); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"NPUF00000/EBOOT.PBP":    "USRDIR/CONTENT/EBOOT.PBP",
+		"NPUF00000/DOCUMENT.DAT": "USRDIR/CONTENT/DOCUMENT.DAT",
+		"NPUF00000/KEYS.BIN":     "USRDIR/CONTENT/KEYS.BIN",
+		"GAME2/EBOOT.PBP":        "USRDIR/GAME2/EBOOT.PBP",
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.PackageType() != PackageTypePSOne {
+		t.Fatalf("package type %s, want PSOne", r.PackageType())
+	}
+
+	dir := t.TempDir()
+	if err := r.Unpack(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	base := filepath.Join(dir, "pspemu/PSP/GAME")
+	for name, src := range files {
+		data, err := ioutil.ReadFile(filepath.Join(base, name))
+		if err != nil || !bytes.Equal(data, fsys[src].Data) {
+			t.Errorf("%s wasn't extracted: %v", name, err)
+		}
+	}
+
+	for _, name := range []string{"NPUF00000/ICON0.PNG", "NPUF00000/PARAM.SFO", "PARAM.SFO"} {
+		if _, err := os.Stat(filepath.Join(base, name)); err == nil {
+			t.Errorf("%s was extracted", name)
+		}
+	}
+
+	r, err = NewReader(bytes.NewReader(buf.Bytes()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir = t.TempDir()
+	if err := r.CreateZip(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.OpenReader(filepath.Join(dir, "PSOne Game [NPUF00000] [USA].zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer zr.Close()
+
+	zipped := map[string]bool{}
+	for _, f := range zr.File {
+		zipped[f.Name] = true
+	}
+
+	for name := range files {
+		if !zipped["pspemu/PSP/GAME/"+name] {
+			t.Errorf("%s isn't in the zip", name)
+		}
+	}
+}
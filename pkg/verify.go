@@ -0,0 +1,290 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha1"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/big"
+)
+
+// npdrmCurve is the curve of the NPDRM ECDSA signatures, the VSH curve of
+// the PS3.
+var npdrmCurve = &elliptic.CurveParams{
+	Name:    "NPDRM",
+	BitSize: 160,
+	P:       hexInt("ffffffffffffffff00000001ffffffffffffffff"),
+	N:       hexInt("00fffffffffffffffeffffb5ae3c523e63944f2127"),
+	B:       hexInt("a68bedc33418029c1d3ce33b9a321fccbb9e0f0b"),
+	Gx:      hexInt("128ec4256487fd8fdf64e2437bc0a1f6d5afde2c"),
+	Gy:      hexInt("5958557eb1db001260425524dbc379d5ac5f4adf"),
+}
+
+// npdrmPublicKey is the public key used to check the NPDRM ECDSA signatures
+// of the pkg header and tail.
+var npdrmPublicKey = &ecdsa.PublicKey{
+	Curve: npdrmCurve,
+	X:     hexInt("6227b00a02856fb04108876719e0a0183291eeb9"),
+	Y:     hexInt("6e736abf81f70ee9161b0ddeb026761aff7bc85b"),
+}
+
+// NpdrmPublicKey returns a copy of the public key used to check the NPDRM
+// ECDSA signatures of the pkg header and tail.
+func NpdrmPublicKey() *ecdsa.PublicKey {
+	return &ecdsa.PublicKey{
+		Curve: npdrmPublicKey.Curve,
+		X:     new(big.Int).Set(npdrmPublicKey.X),
+		Y:     new(big.Int).Set(npdrmPublicKey.Y),
+	}
+}
+
+// ErrTailNotRead is returned by VerifyTail before the pkg was read until the
+// end.
+var ErrTailNotRead = errors.New("pkg: the pkg tail wasn't read yet")
+
+func hexInt(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic("invalid hex number: " + s)
+	}
+
+	return n
+}
+
+// headerDigestSize is the length of the header area covered by the digests.
+const headerDigestSize = 0x80
+
+type CheckStatus int
+
+const (
+	CheckSkipped CheckStatus = iota
+	CheckOK
+	CheckFailed
+)
+
+func (s CheckStatus) String() string {
+	switch s {
+	case CheckOK:
+		return "OK"
+	case CheckFailed:
+		return "FAILED"
+	default:
+		return "SKIPPED"
+	}
+}
+
+func checkStatus(ok bool) CheckStatus {
+	if ok {
+		return CheckOK
+	}
+
+	return CheckFailed
+}
+
+// HeaderReport holds the result of every integrity check made by VerifyHeader.
+type HeaderReport struct {
+	// SHA1 fragment of the header stored in HeaderSha1Hash
+	SHA1 CheckStatus
+	// AES-CMAC of the header stored in HeaderCmacHash
+	CMAC CheckStatus
+	// NPDRM ECDSA signature stored in HeaderNpdrmSignature, skipped when the
+	// package isn't signed
+	Signature CheckStatus
+}
+
+// Valid reports whether none of the checks failed.
+func (r *HeaderReport) Valid() bool {
+	return r.SHA1 != CheckFailed &&
+		r.CMAC != CheckFailed &&
+		r.Signature != CheckFailed
+}
+
+// TailReport holds the result of the checks of the pkg tail, made once the
+// pkg has been read until the end.
+type TailReport struct {
+	// SHA1 of the whole pkg stored at the end of the tail, skipped with
+	// HashSkip
+	FileHash CheckStatus
+	// NPDRM ECDSA signature of the pkg contents before the tail, stored at
+	// the start of the tail. Skipped when the package isn't signed
+	Signature CheckStatus
+}
+
+// Valid reports whether none of the checks failed.
+func (r *TailReport) Valid() bool {
+	return r.FileHash != CheckFailed && r.Signature != CheckFailed
+}
+
+// verifySignature checks a NPDRM ECDSA signature (r and s of 20 bytes each)
+// of the SHA1 digest. Empty signatures are skipped.
+func verifySignature(sig, digest []byte) CheckStatus {
+	if len(sig) < 0x28 || isZero(sig[:0x28]) {
+		return CheckSkipped
+	}
+
+	r := new(big.Int).SetBytes(sig[:20])
+	s := new(big.Int).SetBytes(sig[20:0x28])
+
+	return checkStatus(ecdsa.Verify(npdrmPublicKey, digest, r, s))
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// VerifyHeader checks the digests and the signature of the pkg header. It
+// only needs the headers, see VerifyTail for the checks of the tail.
+func (pr *Reader) VerifyHeader() (*HeaderReport, error) {
+	report := &HeaderReport{}
+	header := pr.rawHeader[:headerDigestSize]
+
+	sum := sha1.Sum(header)
+	report.SHA1 = checkStatus(bytes.Equal(sum[len(sum)-8:], pr.FileHeader.HeaderSha1Hash[:]))
+
+	mac, err := AESCMAC(KeyPS3, header)
+	if err != nil {
+		return nil, err
+	}
+
+	report.CMAC = checkStatus(bytes.Equal(mac, pr.FileHeader.HeaderCmacHash[:]))
+
+	report.Signature = verifySignature(pr.FileHeader.HeaderNpdrmSignature[:], sum[:])
+
+	return report, nil
+}
+
+// VerifyTail returns the checks of the pkg tail. They are made when the tail
+// is read, after the last entry, so ErrTailNotRead is returned before that.
+func (pr *Reader) VerifyTail() (*TailReport, error) {
+	if pr.tailReport == nil {
+		return nil, ErrTailNotRead
+	}
+
+	return pr.tailReport, nil
+}
+
+// checkTail fills the tail report. The tail holds the signature of the
+// contents before it, then the SHA1 of the whole pkg.
+func (pr *Reader) checkTail(tail, contentsHash []byte) {
+	report := &TailReport{}
+
+	if pr.hasher != nil {
+		report.FileHash = checkStatus(pr.Valid())
+	}
+
+	if contentsHash != nil && len(tail) > fileHashSize {
+		report.Signature = verifySignature(tail[:len(tail)-fileHashSize], contentsHash)
+	}
+
+	pr.tailReport = report
+}
+
+// fileHashSize is the size of the tail area holding the SHA1 of the pkg.
+const fileHashSize = 0x20
+
+// A byteCounter is an io.Writer that only counts the bytes written to it.
+type byteCounter int64
+
+func (c *byteCounter) Write(b []byte) (int, error) {
+	*c += byteCounter(len(b))
+	return len(b), nil
+}
+
+// VerifyResult holds the outcome of Reader.Verify.
+type VerifyResult struct {
+	// checks of the signature and the hash stored in the tail
+	Tail *TailReport
+	// hashes, calculated and from file
+	CalculatedHash []byte
+	FileHash       []byte
+	// bytes read from the input and the size stored in the header
+	Size         int64
+	ExpectedSize int64
+}
+
+// HashOK reports whether the calculated SHA1 matches the one in the tail.
+func (v *VerifyResult) HashOK() bool {
+	return len(v.FileHash) > 0 && string(v.CalculatedHash) == string(v.FileHash)
+}
+
+// SizeOK reports whether the input size matches FileHeader.TotalSize.
+func (v *VerifyResult) SizeOK() bool {
+	return v.Size == v.ExpectedSize
+}
+
+// Valid reports whether the hash and the size are correct and the tail
+// signature didn't fail.
+func (v *VerifyResult) Valid() bool {
+	return v.HashOK() && v.SizeOK() && v.Tail.Valid()
+}
+
+// Verify reads the remaining entries of the pkg without writing them anywhere
+// and calculates the SHA1 of the whole file. The input is read until EOF so
+// trailing data is detected as a size mismatch.
+func (pr *Reader) Verify(ctx context.Context) (*VerifyResult, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		_, err := pr.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := discardContext(ctx, pr); err != nil {
+			return nil, err
+		}
+	}
+
+	extra, err := io.Copy(ioutil.Discard, pr.rawReader)
+	if err != nil {
+		return nil, err
+	}
+
+	tail, err := pr.VerifyTail()
+	if err != nil {
+		return nil, err
+	}
+
+	return &VerifyResult{
+		Tail:           tail,
+		CalculatedHash: pr.CalculatedHash,
+		FileHash:       pr.FileHash,
+		Size:           int64(pr.hashed) + fileHashSize + extra,
+		ExpectedSize:   pr.FileHeader.TotalSize,
+	}, nil
+}
+
+func discardContext(ctx context.Context, r io.Reader) error {
+	buf := make([]byte, 32*1024)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		_, err := r.Read(buf)
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"math/big"
+	"testing"
+)
+
+func TestNpdrmCurve(t *testing.T) {
+	if !npdrmCurve.IsOnCurve(npdrmCurve.Gx, npdrmCurve.Gy) {
+		t.Fatal("the generator isn't on the curve")
+	}
+
+	pub := NpdrmPublicKey()
+	if !npdrmCurve.IsOnCurve(pub.X, pub.Y) {
+		t.Fatal("the public key isn't on the curve")
+	}
+
+	// the order of the curve takes the generator and the key to infinity
+	for _, p := range [][2]*big.Int{{npdrmCurve.Gx, npdrmCurve.Gy}, {pub.X, pub.Y}} {
+		x, y := npdrmCurve.ScalarMult(p[0], p[1], npdrmCurve.N.Bytes())
+		if x.Sign() != 0 || y.Sign() != 0 {
+			t.Fatal("wrong curve order")
+		}
+	}
+
+	// the callers get a copy of the key
+	pub.X.SetInt64(1)
+	if NpdrmPublicKey().X.Cmp(npdrmPublicKey.X) != 0 || npdrmPublicKey.X.Cmp(pub.X) == 0 {
+		t.Fatal("the public key was changed")
+	}
+}
+
+// signedPackage builds a package with a tail signature area, signing the
+// header and the contents with key.
+func signedPackage(t *testing.T, key *ecdsa.PrivateKey) []byte {
+	pkgData := readTestdata(t, testVitaPkg)
+
+	// make room for the tail signature before the file hash
+	data := pkgData[:len(pkgData)-fileHashSize]
+	binary.BigEndian.PutUint64(data[0x18:], uint64(len(data)+0x40+fileHashSize))
+
+	sum := sha1.Sum(data[:headerDigestSize])
+	mac, err := AESCMAC(KeyPS3, data[:headerDigestSize])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	copy(data[0x80:], mac)
+	copy(data[0x90:], sign(t, key, sum[:]))
+	copy(data[0xb8:], sum[len(sum)-8:])
+
+	contents := sha1.Sum(data)
+	tail := make([]byte, 0x40)
+	copy(tail, sign(t, key, contents[:]))
+	data = append(data, tail...)
+
+	fileHash := sha1.Sum(data)
+	data = append(data, fileHash[:]...)
+	return append(data, make([]byte, fileHashSize-len(fileHash))...)
+}
+
+func sign(t *testing.T, key *ecdsa.PrivateKey, digest []byte) []byte {
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig := make([]byte, 0x28)
+	r.FillBytes(sig[:20])
+	s.FillBytes(sig[20:])
+
+	return sig
+}
+
+func TestVerifySignatures(t *testing.T) {
+	key, err := ecdsa.GenerateKey(npdrmCurve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(pub *ecdsa.PublicKey) { npdrmPublicKey = pub }(npdrmPublicKey)
+	npdrmPublicKey = &key.PublicKey
+
+	data := signedPackage(t, key)
+
+	tests := []struct {
+		name         string
+		offset       int
+		header, tail CheckStatus
+		fileHash     bool
+	}{
+		{"valid", -1, CheckOK, CheckOK, true},
+		// the tail signature covers the header too
+		{"header signature", 0x90, CheckFailed, CheckFailed, false},
+		{"tail signature", len(data) - fileHashSize - 0x40, CheckOK, CheckFailed, false},
+	}
+
+	for _, tt := range tests {
+		pkgData := append([]byte(nil), data...)
+		if tt.offset >= 0 {
+			pkgData[tt.offset] ^= 1
+		}
+
+		r, err := NewReader(bytes.NewReader(pkgData), "")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		header, err := r.VerifyHeader()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if header.Signature != tt.header || header.SHA1 != CheckOK || header.CMAC != CheckOK {
+			t.Errorf("%s: header report %+v", tt.name, header)
+		}
+
+		if _, err := r.VerifyTail(); err != ErrTailNotRead {
+			t.Errorf("%s: VerifyTail before the tail returned %v", tt.name, err)
+		}
+
+		result, err := r.Verify(context.Background())
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if result.Tail.Signature != tt.tail {
+			t.Errorf("%s: tail signature %s, want %s", tt.name, result.Tail.Signature, tt.tail)
+		}
+
+		if result.HashOK() != tt.fileHash || result.Tail.FileHash != checkStatus(tt.fileHash) {
+			t.Errorf("%s: wrong file hash check", tt.name)
+		}
+	}
+}
+
+func TestVerifyUnsigned(t *testing.T) {
+	r, err := NewReader(bytes.NewReader(readTestdata(t, testVitaPkg)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, err := r.VerifyHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if header.Signature != CheckSkipped || !header.Valid() {
+		t.Errorf("unsigned header report %+v", header)
+	}
+
+	result, err := r.Verify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Tail.Signature != CheckSkipped || !result.Valid() {
+		t.Errorf("unsigned tail report %+v", result.Tail)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	data := readTestdata(t, testVitaPkg)
+	ra := openTestPackage(t, data)
+	entryOffset := int(ra.FileHeader.DataOffset + ra.FileHeader.DataSize - 0x100)
+
+	tests := []struct {
+		name           string
+		data           []byte
+		hashOK, sizeOK bool
+	}{
+		{"valid", data, true, true},
+		{"trailing data", append(append([]byte(nil), data...), 0), true, false},
+		{"tampered data", tamper(data, entryOffset), false, true},
+		{"tampered hash", tamper(data, len(data)-fileHashSize), false, true},
+	}
+
+	for _, tt := range tests {
+		r, err := NewReader(bytes.NewReader(tt.data), "")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		result, err := r.Verify(context.Background())
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if result.HashOK() != tt.hashOK || result.SizeOK() != tt.sizeOK {
+			t.Errorf("%s: hash %t, size %t", tt.name, result.HashOK(), result.SizeOK())
+		}
+
+		if result.Valid() != (tt.hashOK && tt.sizeOK) {
+			t.Errorf("%s: valid %t", tt.name, result.Valid())
+		}
+
+		if result.Tail.FileHash != checkStatus(tt.hashOK) {
+			t.Errorf("%s: tail report %+v", tt.name, result.Tail)
+		}
+
+		tail, err := r.VerifyTail()
+		if err != nil || tail != result.Tail {
+			t.Errorf("%s: VerifyTail returned %+v, %v", tt.name, tail, err)
+		}
+	}
+}
+
+func TestVerifyCanceled(t *testing.T) {
+	r, err := NewReader(bytes.NewReader(readTestdata(t, testVitaPkg)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := r.Verify(ctx); err != context.Canceled {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+// tamper returns a copy of data with the byte at off flipped.
+func tamper(data []byte, off int) []byte {
+	data = append([]byte(nil), data...)
+	data[off] ^= 1
+	return data
+}
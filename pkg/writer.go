@@ -13,6 +13,12 @@ type pkgWriter interface {
 	CreateFile(path string, r io.Reader) error
 }
 
+// seekablePkgWriter is implemented by the writers that can create files
+// written out of order, like the CSO images with the index after the data.
+type seekablePkgWriter interface {
+	CreateSeekableFile(path string) (*os.File, error)
+}
+
 type fsPkgWriter struct {
 	basedir string
 }
@@ -43,6 +49,11 @@ func (fs *fsPkgWriter) CreateFile(name string, r io.Reader) error {
 	return nil
 }
 
+// CreateSeekableFile creates the file for writing, the caller closes it.
+func (fs *fsPkgWriter) CreateSeekableFile(name string) (*os.File, error) {
+	return os.Create(path.Join(fs.basedir, name))
+}
+
 func (fs *zipPkgWriter) CreateDir(name string) error {
 	fullPath := path.Join(fs.basedir, name)
 	header := &zip.FileHeader{